@@ -3,96 +3,424 @@ package build
 import (
 	"archive/tar"
 	"archive/zip"
-	"compress/gzip"
+	"compress/flate"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
-)
+	"runtime"
+	"sort"
+	"strings"
+	"time"
 
-func createZipArchive(dst string, files []string) error {
-	zipFile, err := os.Create(dst)
-	if err != nil {
-		return fmt.Errorf("failed to create zip archive: %w", err)
-	}
-	defer zipFile.Close()
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+	"github.com/ulikunitz/xz"
+)
 
-	zipWriter := zip.NewWriter(zipFile)
-	defer zipWriter.Close()
+// ArchiveFile is a file to add to an archive, under the given slash-separated
+// name.
+type ArchiveFile struct {
+	Name string
+	Path string
+}
 
-	for _, filePath := range files {
-		file, err := os.Open(filePath)
+// archiveContents returns the files to archive: the binary, followed by the
+// files in the module matching the archive_files patterns, all within the
+// wrapping directory (if any).
+func archiveContents(opts Options, binaryPath string) ([]ArchiveFile, error) {
+	files := []ArchiveFile{{
+		Name: filepath.Base(binaryPath),
+		Path: binaryPath,
+	}}
+	seen := map[string]bool{files[0].Name: true}
+	for _, pattern := range opts.ArchiveFiles {
+		matches, err := filepath.Glob(filepath.Join(opts.ModuleDir, filepath.FromSlash(pattern)))
 		if err != nil {
-			return fmt.Errorf("failed to open binary file: %w", err)
+			return nil, fmt.Errorf("invalid archive file pattern %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("archive file pattern %q matches no files", pattern)
+		}
+		for _, match := range matches {
+			// directories are archived with all of their files
+			err := filepath.WalkDir(match, func(filePath string, entry fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if !entry.Type().IsRegular() {
+					return nil
+				}
+				name, err := filepath.Rel(opts.ModuleDir, filePath)
+				if err != nil || !filepath.IsLocal(name) {
+					return fmt.Errorf("archive file %s is outside of the module", filePath)
+				}
+				name = filepath.ToSlash(name)
+				if seen[name] {
+					if name == files[0].Name {
+						return fmt.Errorf("archive file %s has the same name as the binary", name)
+					}
+					return nil
+				}
+				seen[name] = true
+				files = append(files, ArchiveFile{Name: name, Path: filePath})
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
 		}
-		defer file.Close()
+	}
 
-		stat, err := file.Stat()
-		if err != nil {
-			return fmt.Errorf("failed to stat binary file: %w", err)
+	if opts.ArchiveWrapDir != "" {
+		for i := range files {
+			files[i].Name = path.Join(opts.ArchiveWrapDir, files[i].Name)
 		}
+	}
+	return files, nil
+}
 
-		header, err := zip.FileInfoHeader(stat)
+// archiveEntry is a file to add to an archive. Entries are normalized so that
+// archives of the same files are identical: they are sorted by name, owned by
+// root, and have mode 0755 if executable and 0644 otherwise.
+type archiveEntry struct {
+	Path    string
+	Name    string
+	Mode    os.FileMode
+	Size    int64
+	ModTime time.Time
+}
+
+// archiveEntries stats the files to archive. If modTime is set, it's used for
+// every entry instead of the modification time of the file.
+func archiveEntries(files []ArchiveFile, modTime time.Time) ([]archiveEntry, error) {
+	var entries []archiveEntry
+	for _, file := range files {
+		stat, err := os.Stat(file.Path)
 		if err != nil {
-			return fmt.Errorf("failed to create file info header: %w", err)
+			return nil, fmt.Errorf("failed to stat %s: %w", file.Name, err)
 		}
 
-		subFile, err := zipWriter.CreateHeader(header)
-		if err != nil {
-			return fmt.Errorf("failed to create zip header: %w", err)
+		entry := archiveEntry{
+			Path:    file.Path,
+			Name:    file.Name,
+			Mode:    0644,
+			Size:    stat.Size(),
+			ModTime: modTime,
 		}
-		header.Name = filepath.Base(filePath)
+		if stat.Mode()&0111 != 0 {
+			entry.Mode = 0755
+		}
+		if entry.ModTime.IsZero() {
+			entry.ModTime = stat.ModTime()
+		}
+		// archive formats only store whole seconds
+		entry.ModTime = entry.ModTime.UTC().Truncate(time.Second)
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
 
-		_, err = io.Copy(subFile, file)
-		if err != nil {
-			return fmt.Errorf("failed to write binary to zip archive: %w", err)
+// archiver writes entries to an archive in a particular format.
+type archiver interface {
+	// WriteEntry adds the entry to the archive, reading its contents from r.
+	WriteEntry(entry archiveEntry, r io.Reader) error
+	// Close finishes the archive, without closing the underlying writer.
+	Close() error
+}
+
+// archiveFormats are the supported archive formats, keyed by their
+// extension. level is the compression level, or 0 for the default.
+var archiveFormats = map[string]func(w io.Writer, level int) (archiver, error){
+	"zip":     newZipArchiver,
+	"tar":     newTarArchiver(nil),
+	"tar.gz":  newTarArchiver(newGzipWriter),
+	"tar.xz":  newTarArchiver(newXzWriter),
+	"tar.zst": newTarArchiver(newZstdWriter),
+}
+
+// NoArchive is the archive format that leaves binaries unarchived.
+const NoArchive = "none"
+
+func validateArchiveFormat(format string) error {
+	if _, ok := archiveFormats[format]; !ok && format != NoArchive {
+		var formats []string
+		for format := range archiveFormats {
+			formats = append(formats, format)
+		}
+		sort.Strings(formats)
+		return fmt.Errorf("invalid archive format %q (must be one of %s or %s)", format, strings.Join(formats, ", "), NoArchive)
+	}
+	return nil
+}
+
+func createArchive(dst, format string, level int, files []ArchiveFile, modTime time.Time) error {
+	newArchiver, ok := archiveFormats[format]
+	if !ok {
+		return fmt.Errorf("invalid archive format %q", format)
+	}
+	entries, err := archiveEntries(files, modTime)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create %s archive: %w", format, err)
+	}
+	defer file.Close()
+
+	archive, err := newArchiver(file, level)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := writeEntry(archive, entry); err != nil {
+			return fmt.Errorf("failed to write %s to %s archive: %w", entry.Name, format, err)
 		}
 	}
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("failed to write %s archive: %w", format, err)
+	}
+
+	return file.Close()
+}
 
+func writeEntry(archive archiver, entry archiveEntry) error {
+	file, err := os.Open(entry.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return archive.WriteEntry(entry, file)
+}
+
+// checkLevel returns an error if level is neither 0 (the default) nor within
+// [min, max].
+func checkLevel(format string, level, min, max int) error {
+	if level != 0 && (level < min || level > max) {
+		return fmt.Errorf("compression level for %s must be between %d and %d", format, min, max)
+	}
 	return nil
 }
 
-func createTarGzArchive(dst string, files []string) error {
-	tarFile, err := os.Create(dst)
+type zipArchiver struct {
+	writer *zip.Writer
+}
+
+func newZipArchiver(w io.Writer, level int) (archiver, error) {
+	if err := checkLevel("zip", level, 1, 9); err != nil {
+		return nil, err
+	}
+	writer := zip.NewWriter(w)
+	if level != 0 {
+		writer.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, level)
+		})
+	}
+	return zipArchiver{writer: writer}, nil
+}
+
+func (a zipArchiver) WriteEntry(entry archiveEntry, r io.Reader) error {
+	header := &zip.FileHeader{
+		Name:     entry.Name,
+		Method:   zip.Deflate,
+		Modified: entry.ModTime,
+	}
+	header.SetMode(entry.Mode)
+
+	w, err := a.writer.CreateHeader(header)
 	if err != nil {
-		return fmt.Errorf("failed to create tar archive: %w", err)
+		return err
 	}
-	defer tarFile.Close()
+	_, err = io.Copy(w, r)
+	return err
+}
 
-	gzipWriter := gzip.NewWriter(tarFile)
-	defer gzipWriter.Close()
+func (a zipArchiver) Close() error {
+	return a.writer.Close()
+}
 
-	tarWriter := tar.NewWriter(gzipWriter)
-	defer tarWriter.Close()
+type tarArchiver struct {
+	writer     *tar.Writer
+	compressor io.WriteCloser
+}
 
-	for _, filePath := range files {
-		file, err := os.Open(filePath)
-		if err != nil {
-			return fmt.Errorf("failed to open binary file: %w", err)
+// newTarArchiver returns a constructor for tar archives compressed by the
+// given compressor, or uncompressed if it's nil.
+func newTarArchiver(newCompressor func(w io.Writer, level int) (io.WriteCloser, error)) func(io.Writer, int) (archiver, error) {
+	return func(w io.Writer, level int) (archiver, error) {
+		if newCompressor == nil {
+			return tarArchiver{writer: tar.NewWriter(w)}, nil
 		}
-		defer file.Close()
-
-		stat, err := file.Stat()
+		compressor, err := newCompressor(w, level)
 		if err != nil {
-			return fmt.Errorf("failed to stat binary file: %w", err)
+			return nil, err
 		}
+		return tarArchiver{writer: tar.NewWriter(compressor), compressor: compressor}, nil
+	}
+}
 
-		header, err := tar.FileInfoHeader(stat, "")
-		if err != nil {
-			return fmt.Errorf("failed to stat binary file: %w", err)
-		}
-		header.Name = filepath.Base(filePath)
+func (a tarArchiver) WriteEntry(entry archiveEntry, r io.Reader) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     entry.Name,
+		Mode:     int64(entry.Mode),
+		Size:     entry.Size,
+		ModTime:  entry.ModTime,
+		Format:   tar.FormatPAX,
+	}
+	if err := a.writer.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := io.Copy(a.writer, r)
+	return err
+}
 
-		err = tarWriter.WriteHeader(header)
-		if err != nil {
-			return fmt.Errorf("failed to write tar header: %w", err)
-		}
+func (a tarArchiver) Close() error {
+	if err := a.writer.Close(); err != nil {
+		return err
+	}
+	if a.compressor != nil {
+		return a.compressor.Close()
+	}
+	return nil
+}
 
-		_, err = io.Copy(tarWriter, file)
-		if err != nil {
-			return fmt.Errorf("failed to write binary to tar archive: %w", err)
+func newGzipWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if err := checkLevel("tar.gz", level, 1, 9); err != nil {
+		return nil, err
+	}
+	if level == 0 {
+		level = pgzip.DefaultCompression
+	}
+	// blocks are compressed in parallel, which doesn't change the output.
+	// The gzip header is left without a name or modification time.
+	return pgzip.NewWriterLevel(w, level)
+}
+
+// xzDictCaps are the dictionary sizes of the xz presets, which are used as
+// its compression levels.
+var xzDictCaps = []int{
+	1: 1 << 20,
+	2: 2 << 20,
+	3: 4 << 20,
+	4: 4 << 20,
+	5: 8 << 20,
+	6: 8 << 20,
+	7: 16 << 20,
+	8: 32 << 20,
+	9: 64 << 20,
+}
+
+func newXzWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if err := checkLevel("tar.xz", level, 1, 9); err != nil {
+		return nil, err
+	}
+	if level == 0 {
+		level = 6
+	}
+	return xz.WriterConfig{DictCap: xzDictCaps[level]}.NewWriter(w)
+}
+
+func newZstdWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if err := checkLevel("tar.zst", level, 1, 22); err != nil {
+		return nil, err
+	}
+	// frames are compressed with EncodeAll, which may be called
+	// concurrently. The tar stream is never empty, but an empty input still
+	// produces a valid (empty) frame.
+	options := []zstd.EOption{
+		zstd.WithEncoderConcurrency(runtime.GOMAXPROCS(0)),
+		zstd.WithZeroFrames(true),
+	}
+	if level != 0 {
+		options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	}
+	encoder, err := zstd.NewWriter(nil, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &zstdWriter{w: w, encoder: encoder}, nil
+}
+
+// zstdFrameSize is the amount of input compressed into each zstd frame.
+const zstdFrameSize = 4 << 20
+
+// zstdWriter compresses its input into independent zstd frames of
+// zstdFrameSize bytes, up to GOMAXPROCS of which are compressed in parallel,
+// in the same way as pgzip does for gzip. The frames are written in order, so
+// the output doesn't depend on the parallelism, and decompresses as a single
+// stream.
+type zstdWriter struct {
+	w       io.Writer
+	encoder *zstd.Encoder
+	buf     []byte
+	// frames are the frames being compressed, in order
+	frames  []chan []byte
+	started bool
+	err     error
+}
+
+func (z *zstdWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 && z.err == nil {
+		if z.buf == nil {
+			z.buf = make([]byte, 0, zstdFrameSize)
+		}
+		c := zstdFrameSize - len(z.buf)
+		if c > len(p) {
+			c = len(p)
+		}
+		z.buf = append(z.buf, p[:c]...)
+		p = p[c:]
+		if len(z.buf) == zstdFrameSize {
+			z.compress()
 		}
 	}
+	if z.err != nil {
+		return 0, z.err
+	}
+	return n, nil
+}
 
-	return nil
+// compress starts compressing the buffered input into a frame, first
+// waiting for earlier frames to be written if GOMAXPROCS are in progress.
+func (z *zstdWriter) compress() {
+	for len(z.frames) >= runtime.GOMAXPROCS(0) {
+		z.writeFrame()
+	}
+	src := z.buf
+	z.buf = nil
+	frame := make(chan []byte, 1)
+	go func() {
+		frame <- z.encoder.EncodeAll(src, nil)
+	}()
+	z.frames = append(z.frames, frame)
+	z.started = true
+}
+
+// writeFrame waits for the first frame in progress, and writes it.
+func (z *zstdWriter) writeFrame() {
+	frame := <-z.frames[0]
+	z.frames = z.frames[1:]
+	if z.err == nil {
+		_, z.err = z.w.Write(frame)
+	}
+}
+
+func (z *zstdWriter) Close() error {
+	if len(z.buf) > 0 || !z.started {
+		z.compress()
+	}
+	for len(z.frames) > 0 {
+		z.writeFrame()
+	}
+	return z.err
 }
@@ -0,0 +1,278 @@
+package build
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
+)
+
+func TestArchivesAreDeterministic(t *testing.T) {
+	modTime := time.Date(2021, 5, 7, 1, 2, 3, 0, time.UTC)
+
+	writeFiles := func(t *testing.T, fileModTime time.Time) []ArchiveFile {
+		dir := t.TempDir()
+		files := []ArchiveFile{
+			{Name: "def", Path: filepath.Join(dir, "def")},
+			{Name: "README", Path: filepath.Join(dir, "README")},
+		}
+		require.NoError(t, ioutil.WriteFile(files[0].Path, []byte("binary"), 0700))
+		require.NoError(t, ioutil.WriteFile(files[1].Path, []byte("readme"), 0600))
+		for _, file := range files {
+			require.NoError(t, os.Chtimes(file.Path, fileModTime, fileModTime))
+		}
+		return files
+	}
+
+	for _, tt := range []struct {
+		format string
+		level  int
+	}{
+		{format: "zip"},
+		{format: "zip", level: 9},
+		{format: "tar"},
+		{format: "tar.gz"},
+		{format: "tar.gz", level: 1},
+		{format: "tar.xz"},
+		{format: "tar.xz", level: 9},
+		{format: "tar.zst"},
+		{format: "tar.zst", level: 19},
+	} {
+		t.Run(fmt.Sprintf("%s level %d", tt.format, tt.level), func(t *testing.T) {
+			dir := t.TempDir()
+			first := filepath.Join(dir, "first."+tt.format)
+			second := filepath.Join(dir, "second."+tt.format)
+
+			require.NoError(t, createArchive(first, tt.format, tt.level, writeFiles(t, time.Now()), modTime))
+			files := writeFiles(t, time.Now().Add(-time.Hour))
+			files[0], files[1] = files[1], files[0]
+			require.NoError(t, createArchive(second, tt.format, tt.level, files, modTime))
+
+			firstContents, err := ioutil.ReadFile(first)
+			require.NoError(t, err)
+			secondContents, err := ioutil.ReadFile(second)
+			require.NoError(t, err)
+			require.Equal(t, firstContents, secondContents)
+		})
+	}
+}
+
+func TestTarArchiveHeaders(t *testing.T) {
+	modTime := time.Date(2021, 5, 7, 1, 2, 3, 0, time.UTC)
+
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "def")
+	require.NoError(t, ioutil.WriteFile(binary, []byte("binary"), 0700))
+	readme := filepath.Join(dir, "README")
+	require.NoError(t, ioutil.WriteFile(readme, []byte("readme"), 0600))
+	files := []ArchiveFile{
+		{Name: "def-1.0/def", Path: binary},
+		{Name: "def-1.0/README", Path: readme},
+	}
+
+	for _, tt := range []struct {
+		format     string
+		decompress func(r io.Reader) (io.Reader, error)
+	}{
+		{
+			format:     "tar",
+			decompress: func(r io.Reader) (io.Reader, error) { return r, nil },
+		},
+		{
+			format:     "tar.gz",
+			decompress: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		},
+		{
+			format:     "tar.xz",
+			decompress: func(r io.Reader) (io.Reader, error) { return xz.NewReader(r) },
+		},
+		{
+			format:     "tar.zst",
+			decompress: func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+		},
+	} {
+		t.Run(tt.format, func(t *testing.T) {
+			dst := filepath.Join(t.TempDir(), "def."+tt.format)
+			require.NoError(t, createArchive(dst, tt.format, 0, files, modTime))
+
+			file, err := os.Open(dst)
+			require.NoError(t, err)
+			defer file.Close()
+			r, err := tt.decompress(file)
+			require.NoError(t, err)
+			tarReader := tar.NewReader(r)
+
+			var headers []tar.Header
+			var contents []string
+			for {
+				header, err := tarReader.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				headers = append(headers, *header)
+				content, err := io.ReadAll(tarReader)
+				require.NoError(t, err)
+				contents = append(contents, string(content))
+			}
+			require.Len(t, headers, 2)
+			require.Equal(t, "def-1.0/README", headers[0].Name)
+			require.Equal(t, int64(0644), headers[0].Mode)
+			require.Equal(t, "def-1.0/def", headers[1].Name)
+			require.Equal(t, int64(0755), headers[1].Mode)
+			require.Equal(t, []string{"readme", "binary"}, contents)
+			for _, header := range headers {
+				require.Equal(t, 0, header.Uid)
+				require.Equal(t, 0, header.Gid)
+				require.Empty(t, header.Uname)
+				require.True(t, modTime.Equal(header.ModTime))
+			}
+		})
+	}
+}
+
+func TestZipArchiveHeaders(t *testing.T) {
+	modTime := time.Date(2021, 5, 7, 1, 2, 3, 0, time.UTC)
+
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "def.exe")
+	require.NoError(t, ioutil.WriteFile(binary, []byte("binary"), 0700))
+
+	dst := filepath.Join(dir, "def.zip")
+	require.NoError(t, createArchive(dst, "zip", 0, []ArchiveFile{{Name: "def.exe", Path: binary}}, modTime))
+
+	reader, err := zip.OpenReader(dst)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	require.Len(t, reader.File, 1)
+	require.Equal(t, "def.exe", reader.File[0].Name)
+	require.Equal(t, os.FileMode(0755), reader.File[0].Mode())
+	require.True(t, modTime.Equal(reader.File[0].Modified))
+}
+
+func TestZstdWriter(t *testing.T) {
+	// several frames' worth of compressible input, ending in a partial frame
+	var input bytes.Buffer
+	for i := 0; input.Len() < 3*zstdFrameSize+123; i++ {
+		fmt.Fprintf(&input, "line %d\n", i)
+	}
+
+	compress := func(procs int) []byte {
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+		var out bytes.Buffer
+		w, err := newZstdWriter(&out, 0)
+		require.NoError(t, err)
+		_, err = w.Write(input.Bytes())
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return out.Bytes()
+	}
+
+	// the frames don't depend on how many are compressed in parallel
+	compressed := compress(4)
+	require.Equal(t, compress(1), compressed)
+
+	r, err := zstd.NewReader(bytes.NewReader(compressed))
+	require.NoError(t, err)
+	defer r.Close()
+	decompressed, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, input.Bytes(), decompressed)
+}
+
+func TestCreateArchiveErrors(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "def")
+	require.NoError(t, ioutil.WriteFile(binary, []byte("binary"), 0700))
+	files := []ArchiveFile{{Name: "def", Path: binary}}
+
+	err := createArchive(filepath.Join(dir, "def.rar"), "rar", 0, files, time.Time{})
+	require.EqualError(t, err, `invalid archive format "rar"`)
+
+	err = createArchive(filepath.Join(dir, "def.tar.gz"), "tar.gz", 10, files, time.Time{})
+	require.EqualError(t, err, "compression level for tar.gz must be between 1 and 9")
+
+	err = createArchive(filepath.Join(dir, "def.tar.zst"), "tar.zst", 22, files, time.Time{})
+	require.NoError(t, err)
+}
+
+func TestValidateArchiveFormat(t *testing.T) {
+	require.NoError(t, validateArchiveFormat("tar.zst"))
+	require.NoError(t, validateArchiveFormat("none"))
+	require.EqualError(t, validateArchiveFormat("tgz"), `invalid archive format "tgz" (must be one of tar, tar.gz, tar.xz, tar.zst, zip or none)`)
+}
+
+func TestArchiveContents(t *testing.T) {
+	moduleDir := t.TempDir()
+	for _, file := range []string{"LICENSE", "README.md", "completions/def.bash", "completions/def.zsh", "man/def.1"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(moduleDir, filepath.Dir(file)), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(moduleDir, file), nil, 0644))
+	}
+	binaryPath := filepath.Join(t.TempDir(), "def")
+
+	for _, tt := range []struct {
+		desc    string
+		files   []string
+		wrapDir string
+		names   []string
+		err     string
+	}{
+		{
+			desc:  "binary only",
+			names: []string{"def"},
+		},
+		{
+			desc:  "globs and directories",
+			files: []string{"LICENSE", "README*", "completions", "man/*.1", "*.md"},
+			names: []string{"def", "LICENSE", "README.md", "completions/def.bash", "completions/def.zsh", "man/def.1"},
+		},
+		{
+			desc:    "wrapping directory",
+			files:   []string{"LICENSE"},
+			wrapDir: "def-v1.2.3",
+			names:   []string{"def-v1.2.3/def", "def-v1.2.3/LICENSE"},
+		},
+		{
+			desc:  "no matches",
+			files: []string{"NOTICE"},
+			err:   `archive file pattern "NOTICE" matches no files`,
+		},
+		{
+			desc:  "invalid pattern",
+			files: []string{"[a"},
+			err:   `invalid archive file pattern "[a": syntax error in pattern`,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			files, err := archiveContents(Options{
+				ModuleDir:      moduleDir,
+				ArchiveFiles:   tt.files,
+				ArchiveWrapDir: tt.wrapDir,
+			}, binaryPath)
+			if tt.err != "" {
+				require.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+
+			var names []string
+			for _, file := range files {
+				names = append(names, file.Name)
+			}
+			require.Equal(t, tt.names, names)
+		})
+	}
+}
@@ -1,23 +1,26 @@
 package build
 
 import (
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"syscall"
 	"text/template"
+	"time"
 
+	"github.com/aoldershaw/prototype-experiments/go/cacheprog"
 	"github.com/aoldershaw/prototype-experiments/go/module"
 	"github.com/aoldershaw/prototype-sdk-go"
 )
 
-const DefaultOutputTemplate = "{{.Dir}}-{{.OS}}-{{.Arch}}"
-
 var DefaultPlatform = Platform{
 	OS:   runtime.GOOS,
 	Arch: runtime.GOARCH,
@@ -28,10 +31,37 @@ type Params struct {
 	OS      OneOrMany `json:"os"`
 	Arch    OneOrMany `json:"arch"`
 
+	// SkipPlatforms are platforms to exclude from the matrix. They may
+	// contain glob patterns, e.g. "windows/*".
 	SkipPlatforms []Platform `json:"skip_platforms"`
 
+	// Include adds builds to the matrix, or overrides the options of builds
+	// already in it. Exclude removes builds from the matrix.
+	Include []MatrixEntry `json:"include"`
+	Exclude []MatrixEntry `json:"exclude"`
+
+	// Variants are named sets of options (e.g. "pure" and "cgo-sqlite"). If
+	// set, every package and platform is built once for each variant.
+	Variants []Variant `json:"variants"`
+
+	// OutputTemplate is the path of each binary within the output directory
+	// (see OutputTemplateParams).
 	OutputTemplate string `json:"output_template"`
 
+	// Version and Commit identify the source being built, and are available
+	// to the output template. By default, they are derived from the git
+	// repository of the module (see VersionInfo).
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+
+	// VersionVariables maps string variables (of the form importpath.name)
+	// to templates for their values, which are set with -X linker flags on
+	// every build. The templates have the same parameters as the output
+	// template, e.g.
+	//
+	//   {"main.version": "{{.Version}}", "main.commit": "{{.Commit}}"}
+	VersionVariables map[string]string `json:"version_variables"`
+
 	Ldflags         string              `json:"ldflags"`
 	PlatformLdflags map[Platform]string `json:"platform_ldflags"`
 
@@ -47,50 +77,144 @@ type Params struct {
 	Race    bool     `json:"race"`
 	Cgo     bool     `json:"cgo"`
 
-	Parallelism int `json:"parallelism"`
+	// Reproducible builds with -trimpath, and gives the entries of archives a
+	// fixed modification time: SOURCE_DATE_EPOCH if set, and otherwise the
+	// commit time.
+	Reproducible bool `json:"reproducible"`
 
-	Archive bool   `json:"archive"`
-	SHASum  SHASum `json:"shasum"`
-}
+	// BuildVCS controls whether version control information is stamped into
+	// binaries (-buildvcs). If unset, it's left to the go command.
+	BuildVCS *bool `json:"buildvcs"`
 
-// Platforms returns the list of platforms defined in the build matrix. It
-// includes those platforms that were marked as skipped in SkipPlatforms, which
-// should be filtered out elsewhere.
-func (p Params) Platforms() []Platform {
-	if len(p.OS) == 0 {
-		p.OS = OneOrMany{DefaultPlatform.OS}
-	}
-	if len(p.Arch) == 0 {
-		p.Arch = OneOrMany{DefaultPlatform.Arch}
-	}
-	var platforms []Platform
-	for _, os := range p.OS {
-		for _, arch := range p.Arch {
-			platforms = append(platforms, Platform{OS: os, Arch: arch})
-		}
-	}
-	return platforms
-}
+	// VerifyReproducible builds every target a second time with an empty
+	// build cache, and fails the build if the two binaries differ.
+	VerifyReproducible bool `json:"verify_reproducible"`
+
+	Parallelism int `json:"parallelism"`
 
-type OutputTemplateParams struct {
-	Dir  string
-	OS   string
-	Arch string
+	// FailFast cancels any running builds and skips the remaining builds as
+	// soon as one build fails.
+	FailFast bool `json:"fail_fast"`
+
+	// Timeout limits how long each build may take, e.g. "10m".
+	Timeout Duration `json:"timeout"`
+
+	// Retries is the number of times to retry a build that failed for a
+	// transient reason (network errors, being killed or timing out).
+	Retries int `json:"retries"`
+
+	Archive bool `json:"archive"`
+
+	// ArchiveFormat is the format of archives: zip, tar, tar.gz, tar.xz,
+	// tar.zst or none (to leave binaries unarchived). By default, archives
+	// are zip files on Windows and tar.gz files otherwise. It may be set per
+	// platform, or per OS with platforms such as "windows/*".
+	ArchiveFormat         string              `json:"archive_format"`
+	PlatformArchiveFormat map[Platform]string `json:"platform_archive_format"`
+
+	// CompressionLevel is the compression level of archives, from 1 to 9 (or
+	// 22 for tar.zst). If unset, each format's default level is used.
+	//
+	// tar.gz and tar.zst archives are compressed in parallel, in independent
+	// blocks. For tar.zst, each 4 MiB of the archive is a separate zstd frame,
+	// which compresses slightly worse than a single frame would.
+	CompressionLevel int `json:"compression_level"`
+
+	// ArchiveTemplate is the path of each archive within the output
+	// directory. It has the same parameters as the output template, and the
+	// archive format is appended as an extension if the template doesn't use
+	// it. By default, archives are named after their binary.
+	ArchiveTemplate string `json:"archive_template"`
+
+	// ArchiveWrapDir is a template for a top-level directory to place the
+	// contents of each archive in, e.g. "{{.Dir}}-{{.Version}}".
+	ArchiveWrapDir string `json:"archive_wrap_dir"`
+
+	// ArchiveFiles are glob patterns of files in the module to add to each
+	// archive alongside the binary (e.g. "LICENSE" or "completions/*").
+	// Matching directories are added with all of their files.
+	ArchiveFiles         []string              `json:"archive_files"`
+	PlatformArchiveFiles map[Platform][]string `json:"platform_archive_files"`
+
+	// SHASum is the algorithm to checksum outputs with: sha1, sha256,
+	// sha512, blake2b or blake3 (or true for sha1). It defaults to sha256
+	// if ChecksumMode is combined or both.
+	SHASum SHASum `json:"shasum"`
+
+	// ChecksumMode selects whether to write a sidecar checksum file next to
+	// each output (sidecar, the default), a single file covering every output
+	// (combined), or both.
+	ChecksumMode string `json:"checksum_mode"`
+
+	// ChecksumFile is the name of the combined checksum file, which defaults
+	// to the algorithm followed by "SUMS" (e.g. "SHA256SUMS"). It may use the
+	// version parameters of the output template, e.g.
+	// "{{.Version}}_checksums.txt".
+	ChecksumFile string `json:"checksum_file"`
+
+	// Sign creates detached signatures of each output, after archiving and
+	// checksumming, and of the combined checksum file.
+	Sign *Signing `json:"sign"`
+
+	Caches
+
+	// GocacheMaxSize is the maximum size of the emitted build cache. The
+	// least recently used entries are removed to fit within the limit.
+	GocacheMaxSize ByteSize `json:"gocache_max_size"`
+
+	// RemoteCache is the URL of a cache server (see the cache-server
+	// subcommand) to share build outputs with. Outputs are still stored in
+	// the local build cache.
+	RemoteCache string `json:"remote_cache"`
+
+	// Offline prevents every go command run by the build (including those
+	// resolving packages and platforms) from accessing the network, whether
+	// to download modules or toolchains. Before building, every dependency
+	// is checked to be present in either the vendor directory (with mod:
+	// vendor, the default if the module is vendored) or the module cache
+	// seeded from Gopath (with mod: readonly).
+	Offline bool `json:"offline"`
 }
 
 type ID struct {
 	Platform Platform
 	Package  string
+	Variant  string
+}
+
+func (id ID) String() string {
+	if id.Variant != "" {
+		return id.Platform.String() + " " + id.Package + " [" + id.Variant + "]"
+	}
+	return id.Platform.String() + " " + id.Package
 }
 
 type Options struct {
 	ID
 
-	OutputDir  string
+	OutputDir string
+	ModuleDir string
+	// TempDir is the directory to create each build's temporary directory
+	// in, or the default temporary directory if empty.
+	TempDir    string
 	BinaryName string
 	Archive    bool
 	SHASum     SHASum
-	Gopath     string
+	Signer     Signer
+
+	// ArchiveName is the path of the archive within the output directory,
+	// and ArchiveWrapDir the directory to place its contents in.
+	ArchiveName      string
+	ArchiveWrapDir   string
+	ArchiveFiles     []string
+	ArchiveFormat    string
+	CompressionLevel int
+	// ModTime is the modification time of archive entries. If zero, the
+	// modification times of the files are used.
+	ModTime   time.Time
+	Gopath    string
+	Gocache   string
+	CacheProg string
 
 	Ldflags  string
 	Gcflags  string
@@ -100,11 +224,21 @@ type Options struct {
 	Rebuild  bool
 	Race     bool
 	Cgo      bool
+	Offline  bool
+	Trimpath bool
+	BuildVCS *bool
+	Env      []string
+
+	Timeout time.Duration
+	Retries int
+
+	VerifyReproducible bool
 }
 
 type Module interface {
 	Execute(*exec.Cmd) error
 	ResolvePackages(packages ...string) ([]module.Package, error)
+	Info() (module.Info, error)
 }
 
 func Build(mod Module, params Params) ([]prototype.MessageResponse, error) {
@@ -114,12 +248,16 @@ func Build(mod Module, params Params) ([]prototype.MessageResponse, error) {
 		return nil, fmt.Errorf("failed to create output directory: %w", err)
 	}
 
-	gopathDir := "./gopath"
-	err = os.MkdirAll(gopathDir, 0755)
+	gopathDir, gocacheDir, err := params.Caches.Prepare()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create gopath directory: %w", err)
+		return nil, err
 	}
 
+	// cancel the builds on SIGTERM, so that the go command and any compilers
+	// it started are killed rather than orphaned
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
 	ui := NewUI()
 	uiDone := make(chan struct{})
 	statusCh := make(chan Status, 1)
@@ -131,24 +269,37 @@ func Build(mod Module, params Params) ([]prototype.MessageResponse, error) {
 		close(uiDone)
 	}()
 
-	if err := build(mod, params, outputDir, gopathDir, statusCh); err != nil {
-		return nil, err
-	}
+	buildErr := build(ctx, mod, params, outputDir, gopathDir, gocacheDir, statusCh)
 
 	close(statusCh)
 	<-uiDone
 
 	ui.PrintResult()
 
+	if buildErr != nil {
+		return nil, buildErr
+	}
+
+	if params.GocacheMaxSize > 0 {
+		before, after, err := trimCache(gocacheDir, params.GocacheMaxSize)
+		if err != nil {
+			return nil, err
+		}
+		if before != after {
+			fmt.Printf("\ntrimmed build cache from %s to %s\n", before, after)
+		}
+	}
+
 	return []prototype.MessageResponse{{
 		Object: map[string]interface{}{
-			"built":  prototype.Artifact(outputDir),
-			"gopath": prototype.Artifact(gopathDir),
+			"built":   prototype.Artifact(outputDir),
+			"gopath":  prototype.Artifact(gopathDir),
+			"gocache": prototype.Artifact(gocacheDir),
 		},
 	}}, nil
 }
 
-func build(mod Module, params Params, outputDir, gopathDir string, statusCh chan<- Status) error {
+func build(ctx context.Context, mod Module, params Params, outputDir, gopathDir, gocacheDir string, statusCh chan<- Status) error {
 	// get absolute paths since go command runs in a different directory
 	outputDir, err := filepath.Abs(outputDir)
 	if err != nil {
@@ -158,13 +309,128 @@ func build(mod Module, params Params, outputDir, gopathDir string, statusCh chan
 	if err != nil {
 		return fmt.Errorf("get absolute path: %w", err)
 	}
+	gocacheDir, err = filepath.Abs(gocacheDir)
+	if err != nil {
+		return fmt.Errorf("get absolute path: %w", err)
+	}
+
+	if params.Offline {
+		mod = offlineModule{mod}
+		params.ModMode, err = checkOffline(mod, params.ModMode, gopathDir, gocacheDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	var cacheProg string
+	if params.RemoteCache != "" {
+		executable, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("locate executable: %w", err)
+		}
+		cacheProg, err = cacheprog.Command(executable, params.RemoteCache, filepath.Join(gocacheDir, "prog"))
+		if err != nil {
+			return err
+		}
+	}
 
 	if params.OutputTemplate == "" {
 		params.OutputTemplate = DefaultOutputTemplate
 	}
-	outputTemplate, err := template.New("output").Parse(params.OutputTemplate)
+	outputTemplates := map[string]*template.Template{}
+	for _, text := range params.outputTemplates() {
+		outputTemplates[text], err = parseOutputTemplate(text)
+		if err != nil {
+			return fmt.Errorf("invalid output template: %w", err)
+		}
+	}
+	var archiveTemplate, wrapDirTemplate *template.Template
+	if params.ArchiveTemplate != "" {
+		archiveTemplate, err = parseOutputTemplate(params.ArchiveTemplate)
+		if err != nil {
+			return fmt.Errorf("invalid archive template: %w", err)
+		}
+	}
+	if params.ArchiveWrapDir != "" {
+		wrapDirTemplate, err = parseOutputTemplate(params.ArchiveWrapDir)
+		if err != nil {
+			return fmt.Errorf("invalid archive wrap dir: %w", err)
+		}
+	}
+	versionTemplates, err := parseVersionVariables(params.VersionVariables)
+	if err != nil {
+		return err
+	}
+
+	info, err := mod.Info()
 	if err != nil {
-		return fmt.Errorf("invalid output template: %w", err)
+		return fmt.Errorf("failed to get module info: %w", err)
+	}
+
+	version, err := versionInfo(mod)
+	if err != nil {
+		fmt.Printf("could not determine version from git: %s\n\n", err)
+		if !params.Reproducible {
+			version.Date = time.Now().UTC()
+		}
+	}
+	if params.Version != "" {
+		version.Version = params.Version
+	}
+	if params.Commit != "" {
+		version.Commit = params.Commit
+	}
+
+	modTime, err := sourceDateEpoch()
+	if err != nil {
+		return err
+	}
+	if modTime.IsZero() && params.Reproducible {
+		modTime = version.Date
+		if modTime.IsZero() {
+			// not a git repository, so there's nothing better to go on
+			modTime = time.Unix(0, 0).UTC()
+		}
+	}
+
+	if err := params.validateVariants(); err != nil {
+		return err
+	}
+	if err := params.validateArchiveFormats(); err != nil {
+		return err
+	}
+	if err := validateChecksumMode(params.ChecksumMode); err != nil {
+		return err
+	}
+	if params.SHASum == "" && (params.ChecksumMode == ChecksumCombined || params.ChecksumMode == ChecksumBoth) {
+		params.SHASum = DefaultSHASum
+	}
+	var signer Signer
+	if params.Sign != nil {
+		signer, err = loadSigner(*params.Sign)
+		if err != nil {
+			return err
+		}
+	}
+
+	var checksumFile string
+	if params.SHASum != "" && params.ChecksumMode != "" && params.ChecksumMode != ChecksumSidecar {
+		if params.ChecksumFile == "" {
+			params.ChecksumFile = defaultChecksumFile(params.SHASum)
+		}
+		tmpl, err := parseOutputTemplate(params.ChecksumFile)
+		if err != nil {
+			return fmt.Errorf("invalid checksum file: %w", err)
+		}
+		checksumFile, err = renderPath(tmpl, OutputTemplateParams{
+			Version: version.Version,
+			Commit:  version.Commit,
+			Date:    version.Date,
+			Dirty:   version.Dirty,
+		}, "", "checksum file %q must be a relative path within the output directory")
+		if err != nil {
+			return err
+		}
 	}
 
 	if len(params.Package) == 0 {
@@ -182,114 +448,256 @@ func build(mod Module, params Params, outputDir, gopathDir string, statusCh chan
 		}
 	}
 
-	platforms := params.Platforms()
+	dist, err := distList(mod)
+	if err != nil {
+		return err
+	}
+	platforms, err := params.Platforms(dist)
+	if err != nil {
+		return err
+	}
+
+	// report the builds that won't run up front, before any compilation
+	var buildIDs []ID
+	for _, buildID := range params.matrix(mainPackages, platforms) {
+		var reason string
+		switch {
+		case matchesAnyPlatform(params.SkipPlatforms, buildID.Platform):
+			reason = "included in skip_platforms"
+		case params.excluded(buildID):
+			reason = "excluded"
+		case !isSupported(dist, buildID.Platform):
+			reason = "unsupported platform"
+		default:
+			buildIDs = append(buildIDs, buildID)
+			continue
+		}
+		statusCh <- Status{
+			ID:     buildID,
+			Status: "skipped",
+			Data:   reason,
+		}
+	}
 
 	parallelism := 1
 	if params.Parallelism > 0 {
 		parallelism = params.Parallelism
 	}
-	numBuildsTotal := len(mainPackages) * len(platforms)
+	numBuildsTotal := len(buildIDs)
 	if parallelism > numBuildsTotal {
 		parallelism = numBuildsTotal
 	}
 	fmt.Printf("running %d build(s) in parallel...\n\n", parallelism)
 	semaphore := make(chan struct{}, parallelism)
 
-	var wg sync.WaitGroup
-	for _, pkg := range mainPackages {
-		for _, platform := range platforms {
-			buildID := ID{Platform: platform, Package: pkg}
-
-			if containsPlatform(params.SkipPlatforms, platform) {
-				statusCh <- Status{
-					ID:     buildID,
-					Status: "skipped",
-					Data:   "included in skip_platforms",
+	// render the outputs of every build before starting any, so that
+	// conflicting outputs fail up front
+	outputNames := map[string]string{}
+	if checksumFile != "" {
+		outputNames[checksumFile] = "the checksum file"
+	}
+	buildOptions := make([]Options, len(buildIDs))
+	for i, buildID := range buildIDs {
+		opts, outputTemplate := params.options(buildID)
+		opts.OutputDir = outputDir
+		opts.ModuleDir = info.Dir
+		opts.Signer = signer
+		opts.Gopath = gopathDir
+		opts.Gocache = gocacheDir
+		opts.CacheProg = cacheProg
+		opts.ModTime = modTime
+
+		templateParams := OutputTemplateParams{
+			Dir:           filepath.Base(buildID.Package),
+			ImportPath:    buildID.Package,
+			OS:            buildID.Platform.OS,
+			Arch:          buildID.Platform.Arch,
+			ArchVariant:   buildID.Platform.Variant,
+			Variant:       buildID.Variant,
+			Ext:           executableExt(buildID.Platform),
+			ArchiveFormat: archiveFormat(opts),
+			Version:       version.Version,
+			Commit:        version.Commit,
+			Date:          version.Date,
+			Dirty:         version.Dirty,
+		}
+		binaryName, err := renderOutputTemplate(outputTemplates[outputTemplate], templateParams)
+		if err != nil {
+			return fmt.Errorf("%s: %w", buildID, err)
+		}
+		versionFlags, err := versionLdflags(versionTemplates, templateParams)
+		if err != nil {
+			return fmt.Errorf("%s: %w", buildID, err)
+		}
+		opts.Ldflags = strings.TrimSpace(opts.Ldflags + " " + versionFlags)
+		// archived binaries are only written to a temporary directory, so
+		// only the archive ends up in the output directory
+		outputs := []string{binaryName}
+
+		if opts.Archive {
+			opts.ArchiveName = binaryName + "." + templateParams.ArchiveFormat
+			if archiveTemplate != nil {
+				opts.ArchiveName, err = renderArchiveTemplate(archiveTemplate, templateParams)
+				if err != nil {
+					return fmt.Errorf("%s: %w", buildID, err)
 				}
-				continue
 			}
+			if wrapDirTemplate != nil {
+				opts.ArchiveWrapDir, err = renderWrapDirTemplate(wrapDirTemplate, templateParams)
+				if err != nil {
+					return fmt.Errorf("%s: %w", buildID, err)
+				}
+			}
+			outputs = []string{opts.ArchiveName}
+		}
 
-			wg.Add(1)
-			semaphore <- struct{}{}
+		for _, output := range outputs {
+			if other, ok := outputNames[output]; ok {
+				return fmt.Errorf("%s: output %s is also used by %s", buildID, output, other)
+			}
+		}
+		for _, output := range outputs {
+			outputNames[output] = buildID.String()
+		}
+		opts.BinaryName = binaryName
+		buildOptions[i] = opts
+	}
 
+	// binaries are archived (and rebuilt for verification) in temporary
+	// directories alongside the output directory, rather than in the default
+	// temporary directory, which may be a small tmpfs or another filesystem
+	needsTempDir := false
+	for _, opts := range buildOptions {
+		if opts.Archive || opts.VerifyReproducible {
+			needsTempDir = true
+		}
+	}
+	if needsTempDir {
+		tmpDir, err := os.MkdirTemp(filepath.Dir(outputDir), ".build")
+		if err != nil {
+			return fmt.Errorf("failed to create temp directory: %w", err)
+		}
+		defer os.RemoveAll(tmpDir)
+		for i := range buildOptions {
+			buildOptions[i].TempDir = tmpDir
+		}
+	}
+
+	buildCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var failedMu sync.Mutex
+	var failed []Status
+	order := map[ID]int{}
+	report := func(status Status) {
+		statusCh <- status
+		if status.Status != "error" {
+			return
+		}
+		failedMu.Lock()
+		failed = append(failed, status)
+		failedMu.Unlock()
+		if params.FailFast {
+			cancel()
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i, buildID := range buildIDs {
+		order[buildID] = len(order)
+
+		if !acquire(buildCtx, semaphore) {
 			statusCh <- Status{
 				ID:     buildID,
-				Status: "start",
+				Status: "skipped",
+				Data:   "cancelled",
 			}
+			continue
+		}
 
-			valueOrOverride := func(value string, overrides map[Platform]string) string {
-				if override, ok := overrides[platform]; ok {
-					return override
-				}
-				return value
-			}
+		statusCh <- Status{
+			ID:     buildID,
+			Status: "start",
+		}
 
-			binaryName := new(bytes.Buffer)
-			err := outputTemplate.Execute(binaryName, OutputTemplateParams{
-				Dir:  filepath.Base(pkg),
-				OS:   platform.OS,
-				Arch: platform.Arch,
-			})
-			if err != nil {
-				statusCh <- Status{
-					ID:     buildID,
-					Status: "error",
-					Data:   err.Error(),
-				}
-				<-semaphore
-				continue
-			}
-			if platform.OS == "windows" {
-				binaryName.WriteString(".exe")
-			}
-			buildOptions := Options{
-				ID: buildID,
-
-				OutputDir:  outputDir,
-				BinaryName: binaryName.String(),
-				Archive:    params.Archive,
-				SHASum:     params.SHASum,
-				Gopath:     gopathDir,
-
-				Ldflags:  valueOrOverride(params.Ldflags, params.PlatformLdflags),
-				Gcflags:  valueOrOverride(params.Gcflags, params.PlatformGcflags),
-				Asmflags: valueOrOverride(params.Asmflags, params.PlatformAsmflags),
-				Tags:     params.Tags,
-				ModMode:  params.ModMode,
-				Rebuild:  params.Rebuild,
-				Race:     params.Race,
-				Cgo:      params.Cgo,
+		buildOptions := buildOptions[i]
+		wg.Add(1)
+		go func() {
+			report(buildSingle(buildCtx, mod, buildOptions, statusCh))
+
+			<-semaphore
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		sort.Slice(failed, func(i, j int) bool {
+			return order[failed[i].ID] < order[failed[j].ID]
+		})
+		names := make([]string, len(failed))
+		for i, status := range failed {
+			names[i] = status.ID.String()
+			if status.Class != "" {
+				names[i] += " (" + string(status.Class) + ")"
 			}
-			go func() {
-				statusCh <- buildSingle(mod, buildOptions)
+		}
+		return fmt.Errorf("%d build(s) failed: %s", len(failed), strings.Join(names, ", "))
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("builds interrupted")
+	}
 
-				<-semaphore
-				wg.Done()
-			}()
+	if checksumFile != "" {
+		var ignoredExts []string
+		if signer != nil {
+			ignoredExts = append(ignoredExts, signer.Ext())
+		}
+		if err := writeChecksumFile(outputDir, checksumFile, params.SHASum, ignoredExts...); err != nil {
+			return err
+		}
+		if signer != nil {
+			if _, err := signFile(signer, filepath.Join(outputDir, checksumFile)); err != nil {
+				return err
+			}
 		}
 	}
-	wg.Wait()
 	return nil
 }
 
-func buildSingle(mod Module, opts Options) Status {
-	binaryDir := opts.OutputDir
-	if opts.Archive {
-		// if archiving binaries, emit the binaries to a separate directory -
-		// the archive will be created in the correct output directory
-		binaryDir = "/tmp"
+// acquire takes a slot in the semaphore, or returns false if ctx is cancelled
+// first.
+func acquire(ctx context.Context, semaphore chan struct{}) bool {
+	select {
+	case semaphore <- struct{}{}:
+		if ctx.Err() != nil {
+			<-semaphore
+			return false
+		}
+		return true
+	case <-ctx.Done():
+		return false
 	}
-	binaryPath := filepath.Join(binaryDir, opts.BinaryName)
-	cmd := exec.Command("go", "build", "-o", binaryPath)
+}
+
+func buildCommand(ctx context.Context, opts Options, binaryPath string) (*exec.Cmd, error) {
+	cmd := exec.CommandContext(ctx, "go", "build", "-o", binaryPath)
+	killProcessGroup(cmd)
 	if opts.Rebuild {
 		cmd.Args = append(cmd.Args, "-a")
 	}
-	if opts.ModMode != "" {
+	if opts.ModMode != "" && !opts.Offline {
 		cmd.Args = append(cmd.Args, "-mod", opts.ModMode)
 	}
 	if opts.Race {
 		cmd.Args = append(cmd.Args, "-race")
 	}
+	if opts.Trimpath {
+		cmd.Args = append(cmd.Args, "-trimpath")
+	}
+	if opts.BuildVCS != nil {
+		cmd.Args = append(cmd.Args, fmt.Sprintf("-buildvcs=%t", *opts.BuildVCS))
+	}
 	if len(opts.Tags) > 0 {
 		cmd.Args = append(cmd.Args, "-tags", strings.Join(opts.Tags, ","))
 	}
@@ -306,18 +714,86 @@ func buildSingle(mod Module, opts Options) Status {
 
 	cmd.Env = []string{
 		"GOPATH=" + opts.Gopath,
-		"GOCACHE=" + filepath.Join(opts.Gopath, "cache"),
+		"GOCACHE=" + opts.Gocache,
 		"GOOS=" + opts.Platform.OS,
 		"GOARCH=" + opts.Platform.Arch,
 	}
+	variantEnv, err := opts.Platform.Env()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Env = append(cmd.Env, variantEnv...)
+	if opts.CacheProg != "" {
+		cmd.Env = append(cmd.Env, "GOCACHEPROG="+opts.CacheProg)
+	}
+	if opts.Offline {
+		cmd.Env = append(cmd.Env, "GOFLAGS=-mod="+opts.ModMode)
+	}
 	if opts.Cgo {
 		cmd.Env = append(cmd.Env, "CGO_ENABLED=1")
 	} else {
 		cmd.Env = append(cmd.Env, "CGO_ENABLED=0")
 	}
+	cmd.Env = append(cmd.Env, opts.Env...)
+	return cmd, nil
+}
+
+func buildSingle(ctx context.Context, mod Module, opts Options, statusCh chan<- Status) Status {
+	binaryDir := opts.OutputDir
+	if opts.Archive {
+		// if archiving binaries, emit the binaries to a directory of their
+		// own, and archive them from there into the output directory
+		buildDir, err := os.MkdirTemp(opts.TempDir, "build")
+		if err != nil {
+			return Status{
+				ID:     opts.ID,
+				Status: "error",
+				Data:   fmt.Sprintf("failed to create temp directory: %s", err),
+			}
+		}
+		defer os.RemoveAll(buildDir)
+		binaryDir = buildDir
+	}
+	binaryPath := filepath.Join(binaryDir, opts.BinaryName)
+
+	// cancelled reports the build as cancelled, removing any partial outputs
+	cancelled := func(paths ...string) Status {
+		for _, path := range paths {
+			os.Remove(path)
+		}
+		return Status{
+			ID:     opts.ID,
+			Status: "cancelled",
+		}
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if opts.Timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		}
+		var cmd *exec.Cmd
+		cmd, err = buildCommand(attemptCtx, opts, binaryPath)
+		if err != nil {
+			cancel()
+			return Status{
+				ID:     opts.ID,
+				Status: "error",
+				Data:   err.Error(),
+			}
+		}
+		err = mod.Execute(cmd)
+		timedOut := errors.Is(attemptCtx.Err(), context.DeadlineExceeded)
+		cancel()
+
+		if ctx.Err() != nil {
+			return cancelled(binaryPath)
+		}
+		if err == nil {
+			break
+		}
 
-	err := mod.Execute(cmd)
-	if err != nil {
 		var execErr module.ExecutionError
 		if errors.As(err, &execErr) && strings.Contains(execErr.Stderr, "cmd/go: unsupported GOOS/GOARCH pair") {
 			return Status{
@@ -327,21 +803,66 @@ func buildSingle(mod Module, opts Options) Status {
 			}
 		}
 
-		return Status{
+		class := classifyFailure(err, timedOut)
+		if timedOut {
+			err = fmt.Errorf("timed out after %s", opts.Timeout)
+		}
+		if !class.Transient() || attempt > opts.Retries {
+			return Status{
+				ID:     opts.ID,
+				Status: "error",
+				Data:   err.Error(),
+				Class:  class,
+			}
+		}
+
+		statusCh <- Status{
+			ID:     opts.ID,
+			Status: "retrying",
+			Data:   fmt.Sprintf("attempt %d of %d", attempt+1, opts.Retries+1),
+			Class:  class,
+		}
+	}
+
+	if opts.VerifyReproducible {
+		statusCh <- Status{
 			ID:     opts.ID,
-			Status: "error",
-			Data:   err.Error(),
+			Status: "verifying",
+		}
+		class, err := verifyReproducible(ctx, mod, opts, binaryPath)
+		if ctx.Err() != nil {
+			return cancelled(binaryPath)
+		}
+		if err != nil {
+			return Status{
+				ID:     opts.ID,
+				Status: "error",
+				Data:   err.Error(),
+				Class:  class,
+			}
 		}
 	}
 
 	var outPath string
 	if opts.Archive {
-		if opts.Platform.OS == "windows" {
-			outPath = filepath.Join(opts.OutputDir, opts.BinaryName+".zip")
-			err = createZipArchive(outPath, []string{binaryPath})
-		} else {
-			outPath = filepath.Join(opts.OutputDir, opts.BinaryName+".tar.gz")
-			err = createTarGzArchive(outPath, []string{binaryPath})
+		statusCh <- Status{
+			ID:     opts.ID,
+			Status: "archiving",
+		}
+
+		outPath = filepath.Join(opts.OutputDir, opts.ArchiveName)
+		var files []ArchiveFile
+		files, err = archiveContents(opts, binaryPath)
+		// the output template may place outputs in subdirectories, which
+		// go build creates for binaries, but not for archives
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(outPath), 0755)
+		}
+		if err == nil {
+			err = createArchive(outPath, opts.ArchiveFormat, opts.CompressionLevel, files, opts.ModTime)
+		}
+		if ctx.Err() != nil {
+			return cancelled(binaryPath, outPath)
 		}
 		if err != nil {
 			return Status{
@@ -356,6 +877,23 @@ func buildSingle(mod Module, opts Options) Status {
 
 	if opts.SHASum != "" {
 		err = computeSHASum(outPath, opts.SHASum)
+		if ctx.Err() != nil {
+			return cancelled(binaryPath, outPath, outPath+"."+string(opts.SHASum))
+		}
+		if err != nil {
+			return Status{
+				ID:     opts.ID,
+				Status: "error",
+				Data:   err.Error(),
+			}
+		}
+	}
+
+	if opts.Signer != nil {
+		signaturePath, err := signFile(opts.Signer, outPath)
+		if ctx.Err() != nil {
+			return cancelled(binaryPath, outPath, outPath+"."+string(opts.SHASum), signaturePath)
+		}
 		if err != nil {
 			return Status{
 				ID:     opts.ID,
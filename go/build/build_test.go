@@ -1,9 +1,18 @@
 package build
 
 import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/aoldershaw/prototype-experiments/go/module"
@@ -17,7 +26,18 @@ type Cmd struct {
 
 type fakeModule struct {
 	packages map[string][]module.Package
-	cmds     []Cmd
+	dir      string
+	// stdout is the output of commands, keyed by their space-separated args
+	stdout map[string]string
+	// errs are the errors returned by commands, keyed like stdout
+	errs map[string]error
+	// binary, if set, returns the contents of the binary written by go build
+	binary func(cmd *exec.Cmd) string
+
+	mu   sync.Mutex
+	cmds []Cmd
+	// distEnv is the environment of `go tool dist list`
+	distEnv []string
 }
 
 func (m *fakeModule) ResolvePackages(packages ...string) ([]module.Package, error) {
@@ -33,27 +53,97 @@ func (m *fakeModule) ResolvePackages(packages ...string) ([]module.Package, erro
 }
 
 func (m *fakeModule) Execute(cmd *exec.Cmd) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// every build lists the supported platforms, so leave it out of the
+	// recorded commands
+	if args := strings.Join(cmd.Args, " "); args == "go tool dist list -json" {
+		m.distEnv = cmd.Env
+		distList, ok := m.stdout[args]
+		if !ok {
+			contents, err := ioutil.ReadFile("testdata/distlist.json")
+			if err != nil {
+				return err
+			}
+			distList = string(contents)
+		}
+		fmt.Fprint(cmd.Stdout, distList)
+		return nil
+	}
+
+	// version info is derived from git before building, which is also left
+	// out. Unless stubbed, the module isn't a git repository.
+	if cmd.Args[0] == "git" {
+		args := strings.Join(cmd.Args, " ")
+		if err, ok := m.errs[args]; ok {
+			return err
+		}
+		out, ok := m.stdout[args]
+		if !ok {
+			return errors.New("not a git repository")
+		}
+		fmt.Fprint(cmd.Stdout, out)
+		return nil
+	}
+
 	m.cmds = append(m.cmds, Cmd{
 		Args: cmd.Args,
 		Env:  cmd.Env,
 	})
-	return nil
+	// when offline, packages and module info are resolved with go list
+	// through Execute
+	switch args := strings.Join(cmd.Args, " "); {
+	case args == "go list -m -json":
+		info, _ := m.Info()
+		return json.NewEncoder(cmd.Stdout).Encode(info)
+	case strings.HasPrefix(args, "go list -f {{.Name}}|{{.ImportPath}} "):
+		packages, _ := m.ResolvePackages(cmd.Args[4:]...)
+		for _, pkg := range packages {
+			fmt.Fprintf(cmd.Stdout, "%s|%s\n", pkg.Name, pkg.ImportPath)
+		}
+		return nil
+	}
+	if m.binary != nil && cmd.Args[1] == "build" {
+		if err := ioutil.WriteFile(cmd.Args[3], []byte(m.binary(cmd)), 0755); err != nil {
+			return err
+		}
+	}
+	if out, ok := m.stdout[strings.Join(cmd.Args, " ")]; ok {
+		fmt.Fprint(cmd.Stdout, out)
+	}
+	return m.errs[strings.Join(cmd.Args, " ")]
+}
+
+func (m *fakeModule) Info() (module.Info, error) {
+	return module.Info{Path: "github.com/abc/def", Dir: m.dir}, nil
 }
 
 func TestBuild(t *testing.T) {
 	const outputDir = "/output"
 	const gopathDir = "/gopath"
+	const gocacheDir = "/gocache"
 
 	env := func(goos, goarch, cgo string) []string {
 		return []string{
 			"GOPATH=" + gopathDir,
-			"GOCACHE=" + filepath.Join(gopathDir, "cache"),
+			"GOCACHE=" + gocacheDir,
 			"GOOS=" + goos,
 			"GOARCH=" + goarch,
 			"CGO_ENABLED=" + cgo,
 		}
 	}
 
+	executable, err := os.Executable()
+	require.NoError(t, err)
+
+	// when offline, every go command is run with offlineEnv, including those
+	// that otherwise inherit the environment
+	offlineList := []Cmd{
+		{Args: []string{"go", "list", "-m", "-json"}, Env: append(os.Environ(), offlineEnv...)},
+		{Args: []string{"go", "list", "-f", "{{.Name}}|{{.ImportPath}}", "."}, Env: append(os.Environ(), offlineEnv...)},
+	}
+
 	DefaultPlatform = Platform{
 		OS:   "linux",
 		Arch: "amd64",
@@ -62,14 +152,18 @@ func TestBuild(t *testing.T) {
 	for _, tt := range []struct {
 		desc     string
 		packages map[string][]module.Package
+		dir      string
+		stdout   map[string]string
+		errs     map[string]error
 		params   Params
 		commands []Cmd
+		skipped  map[string]string
 		err      string
 	}{
 		{
 			desc: "defaults",
 			packages: map[string][]module.Package{
-				".": {{"main", "github.com/concourse/concourse/cmd/concourse"}},
+				".": {{Name: "main", ImportPath: "github.com/concourse/concourse/cmd/concourse"}},
 			},
 			params: Params{},
 			commands: []Cmd{
@@ -87,13 +181,13 @@ func TestBuild(t *testing.T) {
 			desc: "multiple packages",
 			packages: map[string][]module.Package{
 				"./foo/...": {
-					{"main", "github.com/abc/def/foo"},
-					{"other", "github.com/abc/def/foo/other"},
-					{"packages", "github.com/abc/def/foo/packages"},
-					{"main", "github.com/abc/def/foo/other"},
+					{Name: "main", ImportPath: "github.com/abc/def/foo"},
+					{Name: "other", ImportPath: "github.com/abc/def/foo/other"},
+					{Name: "packages", ImportPath: "github.com/abc/def/foo/packages"},
+					{Name: "main", ImportPath: "github.com/abc/def/foo/other"},
 				},
 				"./bar/...": {
-					{"main", "github.com/abc/def/bar/bar"},
+					{Name: "main", ImportPath: "github.com/abc/def/bar/bar"},
 				},
 			},
 			params: Params{
@@ -129,7 +223,7 @@ func TestBuild(t *testing.T) {
 		{
 			desc: "multiple platforms",
 			packages: map[string][]module.Package{
-				".": {{"main", "github.com/abc/def"}},
+				".": {{Name: "main", ImportPath: "github.com/abc/def"}},
 			},
 			params: Params{
 				OS:   OneOrMany{"linux", "darwin", "windows"},
@@ -177,7 +271,7 @@ func TestBuild(t *testing.T) {
 		{
 			desc: "flags",
 			packages: map[string][]module.Package{
-				".": {{"main", "github.com/abc/def"}},
+				".": {{Name: "main", ImportPath: "github.com/abc/def"}},
 			},
 			params: Params{
 				OS:      OneOrMany{"linux"},
@@ -233,14 +327,765 @@ func TestBuild(t *testing.T) {
 				},
 			},
 		},
+		{
+			desc: "reproducible",
+			packages: map[string][]module.Package{
+				".": {{Name: "main", ImportPath: "github.com/abc/def"}},
+			},
+			params: Params{
+				Reproducible: true,
+				BuildVCS:     boolPtr(false),
+			},
+			commands: []Cmd{
+				{
+					Args: []string{
+						"go", "build",
+						"-o", filepath.Join(outputDir, "def-linux-amd64"),
+						"-trimpath",
+						"-buildvcs=false",
+						"github.com/abc/def",
+					},
+					Env: env("linux", "amd64", "0"),
+				},
+			},
+		},
+		{
+			desc: "per-OS overrides",
+			packages: map[string][]module.Package{
+				".": {{Name: "main", ImportPath: "github.com/abc/def"}},
+			},
+			params: Params{
+				OS:      OneOrMany{"linux", "windows"},
+				Arch:    OneOrMany{"amd64", "arm64"},
+				Ldflags: "-s",
+				PlatformLdflags: map[Platform]string{
+					{OS: "windows", Arch: "*"}:     "-H windowsgui",
+					{OS: "windows", Arch: "arm64"}: "-s -H windowsgui",
+				},
+			},
+			commands: []Cmd{
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-linux-amd64"), "-ldflags", "-s", "github.com/abc/def"},
+					Env:  env("linux", "amd64", "0"),
+				},
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-linux-arm64"), "-ldflags", "-s", "github.com/abc/def"},
+					Env:  env("linux", "arm64", "0"),
+				},
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-windows-amd64.exe"), "-ldflags", "-H windowsgui", "github.com/abc/def"},
+					Env:  env("windows", "amd64", "0"),
+				},
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-windows-arm64.exe"), "-ldflags", "-s -H windowsgui", "github.com/abc/def"},
+					Env:  env("windows", "arm64", "0"),
+				},
+			},
+		},
+		{
+			desc: "unarchived format",
+			packages: map[string][]module.Package{
+				".": {{Name: "main", ImportPath: "github.com/abc/def"}},
+			},
+			params: Params{
+				Archive:       true,
+				ArchiveFormat: "none",
+			},
+			commands: []Cmd{
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-linux-amd64"), "github.com/abc/def"},
+					Env:  env("linux", "amd64", "0"),
+				},
+			},
+		},
+		{
+			desc: "invalid archive format",
+			packages: map[string][]module.Package{
+				".": {{Name: "main", ImportPath: "github.com/abc/def"}},
+			},
+			params: Params{
+				Archive: true,
+				PlatformArchiveFormat: map[Platform]string{
+					{OS: "linux", Arch: "*"}: "tar.bz2",
+				},
+			},
+			err: `invalid archive format "tar.bz2" (must be one of tar, tar.gz, tar.xz, tar.zst, zip or none)`,
+		},
+		{
+			desc: "invalid checksum mode",
+			packages: map[string][]module.Package{
+				".": {{Name: "main", ImportPath: "github.com/abc/def"}},
+			},
+			params: Params{
+				SHASum:       "sha256",
+				ChecksumMode: "sidecars",
+			},
+			err: `invalid checksum mode "sidecars" (must be one of sidecar, combined or both)`,
+		},
+		{
+			desc: "failures",
+			packages: map[string][]module.Package{
+				".": {{Name: "main", ImportPath: "github.com/abc/def"}},
+			},
+			errs: map[string]error{
+				"go build -o " + filepath.Join(outputDir, "def-linux-amd64") + " github.com/abc/def":  errors.New("failed"),
+				"go build -o " + filepath.Join(outputDir, "def-darwin-arm64") + " github.com/abc/def": errors.New("failed"),
+			},
+			params: Params{
+				OS:          OneOrMany{"linux", "darwin"},
+				Arch:        OneOrMany{"amd64", "arm64"},
+				Parallelism: 4,
+			},
+			commands: []Cmd{
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-linux-amd64"), "github.com/abc/def"},
+					Env:  env("linux", "amd64", "0"),
+				},
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-linux-arm64"), "github.com/abc/def"},
+					Env:  env("linux", "arm64", "0"),
+				},
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-darwin-amd64"), "github.com/abc/def"},
+					Env:  env("darwin", "amd64", "0"),
+				},
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-darwin-arm64"), "github.com/abc/def"},
+					Env:  env("darwin", "arm64", "0"),
+				},
+			},
+			err: "2 build(s) failed: linux/amd64 github.com/abc/def (compile), darwin/arm64 github.com/abc/def (compile)",
+		},
+		{
+			desc: "fail fast",
+			packages: map[string][]module.Package{
+				".": {{Name: "main", ImportPath: "github.com/abc/def"}},
+			},
+			errs: map[string]error{
+				"go build -o " + filepath.Join(outputDir, "def-linux-amd64") + " github.com/abc/def": errors.New("failed"),
+			},
+			params: Params{
+				OS:       OneOrMany{"linux"},
+				Arch:     OneOrMany{"amd64", "arm64", "386"},
+				FailFast: true,
+			},
+			commands: []Cmd{
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-linux-amd64"), "github.com/abc/def"},
+					Env:  env("linux", "amd64", "0"),
+				},
+			},
+			err: "1 build(s) failed: linux/amd64 github.com/abc/def (compile)",
+		},
+		{
+			desc: "retries transient failures",
+			packages: map[string][]module.Package{
+				".": {{Name: "main", ImportPath: "github.com/abc/def"}},
+			},
+			errs: map[string]error{
+				"go build -o " + filepath.Join(outputDir, "def-linux-amd64") + " github.com/abc/def": module.ExecutionError{
+					Err:    errors.New("exit status 1"),
+					Stderr: "go: example.com/a@v1.0.0: Get \"https://proxy.golang.org/example.com/a/@v/v1.0.0.mod\": dial tcp: i/o timeout",
+				},
+				"go build -o " + filepath.Join(outputDir, "def-linux-arm64") + " github.com/abc/def": module.ExecutionError{
+					Err:    errors.New("exit status 1"),
+					Stderr: "./main.go:3:1: syntax error",
+				},
+			},
+			params: Params{
+				OS:      OneOrMany{"linux"},
+				Arch:    OneOrMany{"amd64", "arm64"},
+				Retries: 2,
+			},
+			commands: []Cmd{
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-linux-amd64"), "github.com/abc/def"},
+					Env:  env("linux", "amd64", "0"),
+				},
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-linux-amd64"), "github.com/abc/def"},
+					Env:  env("linux", "amd64", "0"),
+				},
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-linux-amd64"), "github.com/abc/def"},
+					Env:  env("linux", "amd64", "0"),
+				},
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-linux-arm64"), "github.com/abc/def"},
+					Env:  env("linux", "arm64", "0"),
+				},
+			},
+			err: "2 build(s) failed: linux/amd64 github.com/abc/def (network), linux/arm64 github.com/abc/def (compile)",
+		},
+		{
+			desc: "include and exclude",
+			packages: map[string][]module.Package{
+				"./...": {
+					{Name: "main", ImportPath: "github.com/abc/def"},
+					{Name: "main", ImportPath: "github.com/abc/def/cmd/other"},
+				},
+			},
+			params: Params{
+				Package:       OneOrMany{"./..."},
+				OS:            OneOrMany{"linux", "windows"},
+				Arch:          OneOrMany{"amd64", "arm64"},
+				SkipPlatforms: []Platform{{OS: "windows", Arch: "*"}},
+				Include: []MatrixEntry{
+					{
+						Platform: &Platform{OS: "linux", Arch: "arm64"},
+						Overrides: Overrides{
+							Cgo: boolPtr(true),
+							Env: map[string]string{"CC": "aarch64-linux-gnu-gcc"},
+						},
+					},
+					{
+						Platform: &Platform{OS: "darwin", Arch: "arm64"},
+						Package:  "def",
+						Overrides: Overrides{
+							Tags: []string{"darwin"},
+						},
+					},
+					{
+						Package: "other",
+						Overrides: Overrides{
+							OutputTemplate: stringPtr("{{.Dir}}_{{.OS}}_{{.Arch}}"),
+						},
+					},
+				},
+				Exclude: []MatrixEntry{
+					{
+						Platform: &Platform{OS: "linux", Arch: "amd64"},
+						Package:  "github.com/abc/def/cmd/*",
+					},
+				},
+			},
+			commands: []Cmd{
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-linux-amd64"), "github.com/abc/def"},
+					Env:  env("linux", "amd64", "0"),
+				},
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-linux-arm64"), "github.com/abc/def"},
+					Env:  append(env("linux", "arm64", "1"), "CC=aarch64-linux-gnu-gcc"),
+				},
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-darwin-arm64"), "-tags", "darwin", "github.com/abc/def"},
+					Env:  env("darwin", "arm64", "0"),
+				},
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "other_linux_arm64"), "github.com/abc/def/cmd/other"},
+					Env:  append(env("linux", "arm64", "1"), "CC=aarch64-linux-gnu-gcc"),
+				},
+			},
+		},
+		{
+			desc: "platform variants",
+			packages: map[string][]module.Package{
+				".": {{Name: "main", ImportPath: "github.com/abc/def"}},
+			},
+			params: Params{
+				OS:   OneOrMany{"linux"},
+				Arch: OneOrMany{"arm/v6", "arm/v7", "amd64/v3", "386/sse2"},
+				PlatformLdflags: map[Platform]string{
+					{OS: "linux", Arch: "arm"}:                "ldflags-arm",
+					{OS: "linux", Arch: "arm", Variant: "v7"}: "ldflags-armv7",
+				},
+				SkipPlatforms: []Platform{{OS: "linux", Arch: "386"}},
+			},
+			commands: []Cmd{
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-linux-arm-v6"), "-ldflags", "ldflags-arm", "github.com/abc/def"},
+					Env:  append(env("linux", "arm", "0")[:4:4], "GOARM=6", "CGO_ENABLED=0"),
+				},
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-linux-arm-v7"), "-ldflags", "ldflags-armv7", "github.com/abc/def"},
+					Env:  append(env("linux", "arm", "0")[:4:4], "GOARM=7", "CGO_ENABLED=0"),
+				},
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-linux-amd64-v3"), "github.com/abc/def"},
+					Env:  append(env("linux", "amd64", "0")[:4:4], "GOAMD64=v3", "CGO_ENABLED=0"),
+				},
+			},
+		},
+		{
+			desc: "unsupported platforms",
+			packages: map[string][]module.Package{
+				".": {{Name: "main", ImportPath: "github.com/abc/def"}},
+			},
+			params: Params{
+				OS:            OneOrMany{"linux", "darwin", "plan9"},
+				Arch:          OneOrMany{"386", "arm64"},
+				SkipPlatforms: []Platform{{OS: "linux", Arch: "386"}},
+			},
+			commands: []Cmd{
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-linux-arm64"), "github.com/abc/def"},
+					Env:  env("linux", "arm64", "0"),
+				},
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-darwin-arm64"), "github.com/abc/def"},
+					Env:  env("darwin", "arm64", "0"),
+				},
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-plan9-386"), "github.com/abc/def"},
+					Env:  env("plan9", "386", "0"),
+				},
+			},
+			skipped: map[string]string{
+				"linux/386 github.com/abc/def":   "included in skip_platforms",
+				"darwin/386 github.com/abc/def":  "unsupported platform",
+				"plan9/arm64 github.com/abc/def": "unsupported platform",
+			},
+		},
+		{
+			desc: "variants",
+			packages: map[string][]module.Package{
+				".": {{Name: "main", ImportPath: "github.com/abc/def"}},
+			},
+			params: Params{
+				OS:      OneOrMany{"linux"},
+				Arch:    OneOrMany{"amd64", "arm64"},
+				Tags:    []string{"netgo"},
+				Ldflags: "-s -w",
+				Variants: []Variant{
+					{Name: "pure"},
+					{
+						Name: "sqlite",
+						Overrides: Overrides{
+							Tags: []string{"sqlite"},
+							Cgo:  boolPtr(true),
+						},
+					},
+				},
+				Include: []MatrixEntry{
+					{
+						Platform: &Platform{OS: "linux", Arch: "arm64"},
+						Variant:  "sqlite",
+						Overrides: Overrides{
+							Env: map[string]string{"CC": "aarch64-linux-gnu-gcc"},
+						},
+					},
+				},
+			},
+			commands: []Cmd{
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-linux-amd64-pure"), "-tags", "netgo", "-ldflags", "-s -w", "github.com/abc/def"},
+					Env:  env("linux", "amd64", "0"),
+				},
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-linux-arm64-pure"), "-tags", "netgo", "-ldflags", "-s -w", "github.com/abc/def"},
+					Env:  env("linux", "arm64", "0"),
+				},
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-linux-amd64-sqlite"), "-tags", "sqlite", "-ldflags", "-s -w", "github.com/abc/def"},
+					Env:  env("linux", "amd64", "1"),
+				},
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "def-linux-arm64-sqlite"), "-tags", "sqlite", "-ldflags", "-s -w", "github.com/abc/def"},
+					Env:  append(env("linux", "arm64", "1"), "CC=aarch64-linux-gnu-gcc"),
+				},
+			},
+		},
+		{
+			desc: "colliding outputs",
+			packages: map[string][]module.Package{
+				".": {{Name: "main", ImportPath: "github.com/abc/def"}},
+			},
+			params: Params{
+				OutputTemplate: "{{.Dir}}-{{.OS}}-{{.Arch}}",
+				Variants:       []Variant{{Name: "a"}, {Name: "b"}},
+			},
+			err: "linux/amd64 github.com/abc/def [b]: output def-linux-amd64 is also used by linux/amd64 github.com/abc/def [a]",
+		},
+		{
+			desc: "output colliding with checksum file",
+			packages: map[string][]module.Package{
+				".": {{Name: "main", ImportPath: "github.com/abc/def"}},
+			},
+			params: Params{
+				OutputTemplate: "SHA256SUMS",
+				// defaults to sha256
+				ChecksumMode: "combined",
+			},
+			err: "linux/amd64 github.com/abc/def: output SHA256SUMS is also used by the checksum file",
+		},
+		{
+			desc: "output template",
+			packages: map[string][]module.Package{
+				".": {{Name: "main", ImportPath: "github.com/abc/def"}},
+			},
+			params: Params{
+				OS:             OneOrMany{"linux", "windows"},
+				OutputTemplate: "{{.Version}}/{{.OS | title}}_{{.Arch}}/{{.Dir}}",
+				Version:        "v1.2.3",
+			},
+			commands: []Cmd{
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "v1.2.3", "Linux_amd64", "def"), "github.com/abc/def"},
+					Env:  env("linux", "amd64", "0"),
+				},
+				{
+					Args: []string{"go", "build", "-o", filepath.Join(outputDir, "v1.2.3", "Windows_amd64", "def.exe"), "github.com/abc/def"},
+					Env:  env("windows", "amd64", "0"),
+				},
+			},
+		},
+		{
+			desc: "version from git",
+			packages: map[string][]module.Package{
+				".": {{Name: "main", ImportPath: "github.com/abc/def"}},
+			},
+			stdout: map[string]string{
+				"git rev-parse HEAD":                             "abcdef1234567890\n",
+				"git show -s --format=%cI HEAD":                  "2021-02-03T04:05:06-05:00\n",
+				"git describe --tags --abbrev=0 --match v[0-9]*": "v1.2.3\n",
+				"git describe --tags --match v[0-9]*":            "v1.2.3-4-gabcdef1\n",
+				"git status --porcelain":                         " M main.go\n",
+			},
+			params: Params{
+				OS:      OneOrMany{"linux"},
+				Arch:    OneOrMany{"amd64", "arm64"},
+				Ldflags: "-s -w",
+				PlatformLdflags: map[Platform]string{
+					{OS: "linux", Arch: "arm64"}: "-s",
+				},
+				OutputTemplate: "{{.Dir}}-{{.Version}}-{{.Arch}}",
+				VersionVariables: map[string]string{
+					"main.version": "{{.Version}}",
+					"main.commit":  "{{.Commit}}",
+					"main.date":    `{{.Date.Format "2006-01-02 15:04:05"}}`,
+					"main.dirty":   "{{.Dirty}}",
+				},
+			},
+			commands: []Cmd{
+				{
+					Args: []string{
+						"go", "build",
+						"-o", filepath.Join(outputDir, "def-v1.2.3-4-gabcdef1-amd64"),
+						"-ldflags", "-s -w -X main.commit=abcdef1234567890 -X 'main.date=2021-02-03 09:05:06' -X main.dirty=true -X main.version=v1.2.3-4-gabcdef1",
+						"github.com/abc/def",
+					},
+					Env: env("linux", "amd64", "0"),
+				},
+				{
+					Args: []string{
+						"go", "build",
+						"-o", filepath.Join(outputDir, "def-v1.2.3-4-gabcdef1-arm64"),
+						"-ldflags", "-s -X main.commit=abcdef1234567890 -X 'main.date=2021-02-03 09:05:06' -X main.dirty=true -X main.version=v1.2.3-4-gabcdef1",
+						"github.com/abc/def",
+					},
+					Env: env("linux", "arm64", "0"),
+				},
+			},
+		},
+		{
+			desc: "version without semver tags",
+			packages: map[string][]module.Package{
+				".": {{Name: "main", ImportPath: "github.com/abc/def"}},
+			},
+			stdout: map[string]string{
+				"git rev-parse HEAD":            "abcdef1234567890\n",
+				"git show -s --format=%cI HEAD": "2021-02-03T04:05:06Z\n",
+				"git status --porcelain":        "",
+			},
+			errs: map[string]error{
+				"git describe --tags --abbrev=0 --match v[0-9]*": errors.New("no names found"),
+			},
+			params: Params{
+				Commit: "override",
+				VersionVariables: map[string]string{
+					"main.version": "{{.Version}}",
+					"main.commit":  "{{.Commit}}",
+				},
+			},
+			commands: []Cmd{
+				{
+					Args: []string{
+						"go", "build",
+						"-o", filepath.Join(outputDir, "def-linux-amd64"),
+						"-ldflags", "-X main.commit=override -X main.version=v0.0.0-abcdef123456",
+						"github.com/abc/def",
+					},
+					Env: env("linux", "amd64", "0"),
+				},
+			},
+		},
+		{
+			desc: "unquotable version variable",
+			packages: map[string][]module.Package{
+				".": {{Name: "main", ImportPath: "github.com/abc/def"}},
+			},
+			params: Params{
+				Version: `it's "quoted"`,
+				VersionVariables: map[string]string{
+					"main.version": "{{.Version}}",
+				},
+			},
+			err: `linux/amd64 github.com/abc/def: invalid version variable main.version: main.version=it's "quoted" cannot be quoted for the go command, as it contains both single and double quotes`,
+		},
+		{
+			desc: "remote cache",
+			packages: map[string][]module.Package{
+				".": {{Name: "main", ImportPath: "github.com/abc/def"}},
+			},
+			params: Params{
+				RemoteCache: "http://cache:8080",
+			},
+			commands: []Cmd{
+				{
+					Args: []string{
+						"go", "build",
+						"-o", filepath.Join(outputDir, "def-linux-amd64"),
+						"github.com/abc/def",
+					},
+					Env: append(env("linux", "amd64", "0")[:4:4],
+						"GOCACHEPROG="+executable+" cacheprog -url http://cache:8080 -dir "+filepath.Join(gocacheDir, "prog"),
+						"CGO_ENABLED=0",
+					),
+				},
+			},
+		},
+		{
+			desc: "offline with module cache",
+			packages: map[string][]module.Package{
+				".": {{Name: "main", ImportPath: "github.com/abc/def"}},
+			},
+			dir: t.TempDir(),
+			params: Params{
+				Offline: true,
+			},
+			commands: []Cmd{
+				offlineList[0],
+				{
+					Args: []string{"go", "mod", "download", "-json"},
+					Env:  append([]string{"GOPATH=" + gopathDir, "GOCACHE=" + gocacheDir, "GOFLAGS=-mod=readonly"}, offlineEnv...),
+				},
+				offlineList[0],
+				offlineList[1],
+				{
+					Args: []string{
+						"go", "build",
+						"-o", filepath.Join(outputDir, "def-linux-amd64"),
+						"github.com/abc/def",
+					},
+					Env: append(env("linux", "amd64", "0")[:4:4],
+						"GOFLAGS=-mod=readonly",
+						"CGO_ENABLED=0",
+						"GOPROXY=off",
+						"GOTOOLCHAIN=local",
+					),
+				},
+			},
+		},
+		{
+			desc: "offline with missing modules",
+			packages: map[string][]module.Package{
+				".": {{Name: "main", ImportPath: "github.com/abc/def"}},
+			},
+			dir: t.TempDir(),
+			stdout: map[string]string{
+				"go mod download -json": `{"Path": "example.com/b", "Version": "v1.2.0", "Error": "module lookup disabled by GOPROXY=off"}
+{"Path": "example.com/c", "Version": "v0.1.0"}
+{"Path": "example.com/a", "Version": "v1.0.0", "Error": "module lookup disabled by GOPROXY=off"}`,
+			},
+			params: Params{
+				Offline: true,
+			},
+			commands: []Cmd{
+				offlineList[0],
+				{
+					Args: []string{"go", "mod", "download", "-json"},
+					Env:  append([]string{"GOPATH=" + gopathDir, "GOCACHE=" + gocacheDir, "GOFLAGS=-mod=readonly"}, offlineEnv...),
+				},
+			},
+			err: "missing from module cache: example.com/a@v1.0.0, example.com/b@v1.2.0",
+		},
+		{
+			desc: "offline with missing go.mod",
+			packages: map[string][]module.Package{
+				".": {{Name: "main", ImportPath: "github.com/abc/def"}},
+			},
+			dir: t.TempDir(),
+			errs: map[string]error{
+				"go mod download -json": module.ExecutionError{
+					Err:    errors.New("exit status 1"),
+					Stderr: "go: example.com/a@v1.0.0 requires\n\texample.com/b@v1.2.0: module lookup disabled by GOPROXY=off\n",
+				},
+			},
+			params: Params{
+				Offline: true,
+			},
+			commands: []Cmd{
+				offlineList[0],
+				{
+					Args: []string{"go", "mod", "download", "-json"},
+					Env:  append([]string{"GOPATH=" + gopathDir, "GOCACHE=" + gocacheDir, "GOFLAGS=-mod=readonly"}, offlineEnv...),
+				},
+			},
+			err: "missing from module cache: example.com/b@v1.2.0",
+		},
+		{
+			desc: "offline with vendor directory",
+			packages: map[string][]module.Package{
+				".": {{Name: "main", ImportPath: "github.com/abc/def"}},
+			},
+			dir: writeVendoredModule(t, "module github.com/abc/def\n", ""),
+			params: Params{
+				Offline: true,
+			},
+			commands: []Cmd{
+				offlineList[0],
+				offlineList[0],
+				offlineList[1],
+				{
+					Args: []string{
+						"go", "build",
+						"-o", filepath.Join(outputDir, "def-linux-amd64"),
+						"github.com/abc/def",
+					},
+					Env: append(env("linux", "amd64", "0")[:4:4],
+						"GOFLAGS=-mod=vendor",
+						"CGO_ENABLED=0",
+						"GOPROXY=off",
+						"GOTOOLCHAIN=local",
+					),
+				},
+			},
+		},
+		{
+			desc: "offline with unsupported mod mode",
+			packages: map[string][]module.Package{
+				".": {{Name: "main", ImportPath: "github.com/abc/def"}},
+			},
+			dir: t.TempDir(),
+			params: Params{
+				Offline: true,
+				ModMode: "mod",
+			},
+			commands: []Cmd{offlineList[0]},
+			err:      `offline mode requires mod to be vendor or readonly, got "mod"`,
+		},
 	} {
-		mod := &fakeModule{packages: tt.packages}
-		err := build(mod, tt.params, outputDir, gopathDir, make(chan Status, 1000))
+		mod := &fakeModule{packages: tt.packages, dir: tt.dir, stdout: tt.stdout, errs: tt.errs}
+		statusCh := make(chan Status, 1000)
+		err := build(context.Background(), mod, tt.params, outputDir, gopathDir, gocacheDir, statusCh)
 		if tt.err != "" {
 			require.EqualError(t, err, tt.err)
 		} else {
 			require.NoError(t, err)
 		}
 		require.ElementsMatch(t, tt.commands, mod.cmds)
+		if tt.params.Offline && tt.err == "" {
+			require.Equal(t, append(os.Environ(), offlineEnv...), mod.distEnv)
+		}
+
+		if tt.skipped != nil {
+			close(statusCh)
+			skipped := map[string]string{}
+			for status := range statusCh {
+				if status.Status == "skipped" {
+					skipped[status.ID.String()] = status.Data
+				}
+			}
+			require.Equal(t, tt.skipped, skipped)
+		}
+	}
+}
+
+func TestBuildArchivesSharingBinaryName(t *testing.T) {
+	// the binaries are archived from temporary directories, so only the
+	// archive names need to be unique
+	outputDir := filepath.Join(t.TempDir(), "output")
+	require.NoError(t, os.MkdirAll(outputDir, 0755))
+
+	mod := &fakeModule{
+		packages: map[string][]module.Package{
+			".": {{Name: "main", ImportPath: "github.com/abc/def"}},
+		},
+		binary: func(cmd *exec.Cmd) string {
+			return strings.Join(cmd.Env, " ")
+		},
+	}
+	statusCh := make(chan Status, 100)
+	err := build(context.Background(), mod, Params{
+		Arch:            OneOrMany{"amd64", "arm64"},
+		OutputTemplate:  "{{.Dir}}",
+		Archive:         true,
+		ArchiveFormat:   "tar",
+		ArchiveTemplate: "{{.Dir}}_{{.OS}}_{{.Arch}}",
+	}, outputDir, "/gopath", "/gocache", statusCh)
+	require.NoError(t, err)
+
+	entries, err := os.ReadDir(outputDir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	require.Equal(t, []string{"def_linux_amd64.tar", "def_linux_arm64.tar"}, names)
+}
+
+func TestBuildSingleArchive(t *testing.T) {
+	outputDir := t.TempDir()
+	tmpDir := t.TempDir()
+
+	// every build writes a binary of the same name, containing its platform
+	mod := &fakeModule{
+		binary: func(cmd *exec.Cmd) string {
+			return strings.Join(cmd.Env, " ")
+		},
+	}
+
+	platforms := []Platform{{OS: "linux", Arch: "amd64"}, {OS: "linux", Arch: "arm64"}}
+	statuses := make([]Status, len(platforms))
+	statusCh := make(chan Status, 100)
+	var wg sync.WaitGroup
+	for i, platform := range platforms {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i] = buildSingle(context.Background(), mod, Options{
+				ID:            ID{Platform: platform, Package: "github.com/abc/def"},
+				OutputDir:     outputDir,
+				TempDir:       tmpDir,
+				BinaryName:    "def",
+				Archive:       true,
+				ArchiveName:   "def-" + platform.Arch + ".tar",
+				ArchiveFormat: "tar",
+			}, statusCh)
+		}()
+	}
+	wg.Wait()
+
+	for i, platform := range platforms {
+		require.Equal(t, "success", statuses[i].Status, statuses[i].Data)
+
+		file, err := os.Open(filepath.Join(outputDir, "def-"+platform.Arch+".tar"))
+		require.NoError(t, err)
+		defer file.Close()
+		tarReader := tar.NewReader(file)
+		header, err := tarReader.Next()
+		require.NoError(t, err)
+		require.Equal(t, "def", header.Name)
+		contents, err := io.ReadAll(tarReader)
+		require.NoError(t, err)
+		require.Contains(t, string(contents), "GOARCH="+platform.Arch)
 	}
+
+	// the binaries are only in the archives, and the temporary directories
+	// are cleaned up
+	entries, err := os.ReadDir(tmpDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+	entries, err = os.ReadDir(outputDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func stringPtr(s string) *string {
+	return &s
 }
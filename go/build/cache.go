@@ -0,0 +1,229 @@
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aoldershaw/prototype-experiments/go/module"
+	"github.com/aoldershaw/prototype-sdk-go"
+)
+
+// ByteSize is a number of bytes. It may be given either as a number, or as a
+// string with a unit suffix (e.g. "500MB", "2GiB").
+type ByteSize int64
+
+var byteSizeUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"KB", 1000},
+	{"MB", 1000 * 1000},
+	{"GB", 1000 * 1000 * 1000},
+	{"K", 1 << 10},
+	{"M", 1 << 20},
+	{"G", 1 << 30},
+	{"B", 1},
+}
+
+func (s *ByteSize) UnmarshalJSON(data []byte) error {
+	{
+		var n int64
+		if err := json.Unmarshal(data, &n); err == nil {
+			*s = ByteSize(n)
+			return nil
+		}
+	}
+	{
+		var str string
+		if err := json.Unmarshal(data, &str); err == nil {
+			size, err := parseByteSize(str)
+			if err != nil {
+				return err
+			}
+			*s = size
+			return nil
+		}
+	}
+	return fmt.Errorf("must be either a number or a string")
+}
+
+func parseByteSize(str string) (ByteSize, error) {
+	str = strings.TrimSpace(str)
+	multiplier := int64(1)
+	for _, unit := range byteSizeUnits {
+		if strings.HasSuffix(str, unit.suffix) {
+			str = strings.TrimSpace(strings.TrimSuffix(str, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(str, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %q", str)
+	}
+	return ByteSize(n * float64(multiplier)), nil
+}
+
+func (s ByteSize) String() string {
+	switch {
+	case s >= 1<<30:
+		return fmt.Sprintf("%.1fGiB", float64(s)/(1<<30))
+	case s >= 1<<20:
+		return fmt.Sprintf("%.1fMiB", float64(s)/(1<<20))
+	case s >= 1<<10:
+		return fmt.Sprintf("%.1fKiB", float64(s)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", int64(s))
+}
+
+// Caches are the params, embedded by every message, for the gopath and
+// gocache artifacts emitted by a previous message. They are used to seed the
+// module cache and build cache, respectively, and every message emits them
+// again once it's done.
+type Caches struct {
+	Gopath  prototype.Artifact `json:"gopath"`
+	Gocache prototype.Artifact `json:"gocache"`
+}
+
+// Prepare creates the gopath and gocache directories, seeded from the
+// artifacts.
+func (c Caches) Prepare() (gopathDir, gocacheDir string, err error) {
+	gopathDir = "./gopath"
+	err = os.MkdirAll(gopathDir, 0755)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create gopath directory: %w", err)
+	}
+	if err := SeedCache(gopathDir, string(c.Gopath)); err != nil {
+		return "", "", fmt.Errorf("failed to seed module cache: %w", err)
+	}
+
+	gocacheDir = "./gocache"
+	err = os.MkdirAll(gocacheDir, 0755)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create gocache directory: %w", err)
+	}
+	if err := SeedCache(gocacheDir, string(c.Gocache)); err != nil {
+		return "", "", fmt.Errorf("failed to seed build cache: %w", err)
+	}
+
+	return gopathDir, gocacheDir, nil
+}
+
+// CacheEnv returns the environment pointing the go command at the module
+// cache and build cache created by Caches.Prepare.
+func CacheEnv(gopathDir, gocacheDir string) []string {
+	return []string{
+		"GOPATH=" + gopathDir,
+		"GOCACHE=" + gocacheDir,
+	}
+}
+
+// SeedCache copies the contents of a cache artifact from a previous build
+// into dir.
+func SeedCache(dir, from string) error {
+	if from == "" {
+		return nil
+	}
+	src, err := filepath.Abs(from)
+	if err != nil {
+		return fmt.Errorf("get absolute path: %w", err)
+	}
+	dst, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("get absolute path: %w", err)
+	}
+	if src == dst {
+		return nil
+	}
+	return copyDir(src, dst)
+}
+
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			// the module cache is read-only, but we need to be able to write
+			// to the directories to populate them
+			return os.MkdirAll(target, 0755)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			os.Remove(target)
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return module.CopyFile(path, target, info.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+// trimCache removes the least recently used entries from the build cache
+// until it is no larger than maxSize. It returns the size of the cache before
+// and after trimming.
+func trimCache(dir string, maxSize ByteSize) (ByteSize, ByteSize, error) {
+	type entry struct {
+		path string
+		info os.FileInfo
+	}
+	var entries []entry
+	var size ByteSize
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		size += ByteSize(info.Size())
+		// entries live in subdirectories named by the first byte of their
+		// hash - leave the top-level bookkeeping files (README, trim.txt)
+		if filepath.Dir(path) == dir {
+			return nil
+		}
+		entries = append(entries, entry{path: path, info: info})
+		return nil
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read build cache: %w", err)
+	}
+
+	before := size
+	if size <= maxSize {
+		return before, size, nil
+	}
+
+	// the go command updates the mtime of cache entries when they are used
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].info.ModTime().Before(entries[j].info.ModTime())
+	})
+	for _, e := range entries {
+		if size <= maxSize {
+			break
+		}
+		if err := os.Remove(e.path); err != nil {
+			return 0, 0, fmt.Errorf("failed to trim build cache: %w", err)
+		}
+		size -= ByteSize(e.info.Size())
+	}
+
+	return before, size, nil
+}
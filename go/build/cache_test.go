@@ -0,0 +1,101 @@
+package build
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestByteSize(t *testing.T) {
+	for input, expected := range map[string]ByteSize{
+		`1024`:      1024,
+		`"512"`:     512,
+		`"10KB"`:    10 * 1000,
+		`"1.5 GiB"`: 3 << 29,
+		`"500M"`:    500 << 20,
+	} {
+		var size ByteSize
+		require.NoError(t, json.Unmarshal([]byte(input), &size), input)
+		require.Equal(t, expected, size, input)
+	}
+
+	var size ByteSize
+	require.Error(t, json.Unmarshal([]byte(`"lots"`), &size))
+	require.Error(t, json.Unmarshal([]byte(`true`), &size))
+}
+
+func TestSeedCache(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+
+	modDir := filepath.Join(src, "pkg", "mod", "example.com", "dep@v1.0.0")
+	require.NoError(t, os.MkdirAll(modDir, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(modDir, "dep.go"), []byte("package dep\n"), 0444))
+	// the module cache is read-only
+	require.NoError(t, os.Chmod(modDir, 0555))
+	defer os.Chmod(modDir, 0755)
+
+	require.NoError(t, SeedCache(dst, src))
+	// seeding twice is fine
+	require.NoError(t, SeedCache(dst, src))
+
+	contents, err := ioutil.ReadFile(filepath.Join(dst, "pkg", "mod", "example.com", "dep@v1.0.0", "dep.go"))
+	require.NoError(t, err)
+	require.Equal(t, "package dep\n", string(contents))
+
+	require.NoError(t, SeedCache(dst, ""))
+}
+
+func TestCachesPrepare(t *testing.T) {
+	src := t.TempDir()
+	for _, dir := range []string{"gopath", "gocache"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(src, dir), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(src, dir, "file"), []byte(dir), 0644))
+	}
+
+	// the caches are embedded in the params of each message
+	var params Params
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"gopath": {"artifact": "`+filepath.Join(src, "gopath")+`"},
+		"gocache": {"artifact": "`+filepath.Join(src, "gocache")+`"}
+	}`), &params))
+
+	t.Chdir(t.TempDir())
+	gopathDir, gocacheDir, err := params.Caches.Prepare()
+	require.NoError(t, err)
+	require.Equal(t, "./gopath", gopathDir)
+	require.Equal(t, "./gocache", gocacheDir)
+	for _, dir := range []string{gopathDir, gocacheDir} {
+		contents, err := ioutil.ReadFile(filepath.Join(dir, "file"))
+		require.NoError(t, err)
+		require.Equal(t, filepath.Base(dir), string(contents))
+	}
+}
+
+func TestTrimCache(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README"), make([]byte, 10), 0644))
+
+	now := time.Now()
+	for i, name := range []string{"00/old-d", "01/newer-d", "02/newest-d"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, make([]byte, 100), 0644))
+		mtime := now.Add(time.Duration(i) * time.Hour)
+		require.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+
+	before, after, err := trimCache(dir, 250)
+	require.NoError(t, err)
+	require.Equal(t, ByteSize(310), before)
+	require.Equal(t, ByteSize(210), after)
+
+	require.NoFileExists(t, filepath.Join(dir, "00", "old-d"))
+	require.FileExists(t, filepath.Join(dir, "01", "newer-d"))
+	require.FileExists(t, filepath.Join(dir, "02", "newest-d"))
+	require.FileExists(t, filepath.Join(dir, "README"))
+}
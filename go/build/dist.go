@@ -0,0 +1,136 @@
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// DistPlatform is a platform supported by the toolchain, as reported by `go
+// tool dist list -json`.
+type DistPlatform struct {
+	GOOS         string
+	GOARCH       string
+	CgoSupported bool
+	FirstClass   bool
+}
+
+func (d DistPlatform) Platform() Platform {
+	return Platform{OS: d.GOOS, Arch: d.GOARCH}
+}
+
+func distList(mod Module) ([]DistPlatform, error) {
+	var stdout bytes.Buffer
+	cmd := exec.Command("go", "tool", "dist", "list", "-json")
+	cmd.Stdout = &stdout
+	if err := mod.Execute(cmd); err != nil {
+		return nil, fmt.Errorf("failed to list supported platforms: %w", err)
+	}
+
+	var platforms []DistPlatform
+	if err := json.Unmarshal(stdout.Bytes(), &platforms); err != nil {
+		return nil, fmt.Errorf("failed to parse supported platforms: %w", err)
+	}
+	return platforms, nil
+}
+
+// isSupported returns whether platform (ignoring its variant) is supported by
+// the toolchain.
+func isSupported(dist []DistPlatform, platform Platform) bool {
+	for _, d := range dist {
+		if d.Platform() == platform.WithoutVariant() {
+			return true
+		}
+	}
+	return false
+}
+
+// expandPattern returns the supported platforms matching pattern, carrying
+// over its variant if given. If pattern isn't a pattern at all, it is returned
+// as-is, whether or not it's supported.
+func expandPattern(dist []DistPlatform, pattern Platform) []Platform {
+	if !pattern.WithoutVariant().isPattern() {
+		return []Platform{pattern}
+	}
+	var platforms []Platform
+	for _, d := range dist {
+		if pattern.WithoutVariant().Match(d.Platform()) {
+			platform := d.Platform()
+			platform.Variant = pattern.Variant
+			platforms = append(platforms, platform)
+		}
+	}
+	return platforms
+}
+
+// presetPattern translates the "all" preset to the equivalent glob pattern.
+func presetPattern(value string) string {
+	if value == "all" {
+		return "*"
+	}
+	return value
+}
+
+// Platforms returns the list of platforms defined in the build matrix, given
+// the platforms supported by the toolchain.
+//
+// Each OS may be a glob pattern (e.g. "*bsd"), "all", "first-class" (the
+// first-class ports of the toolchain), or a platform pattern such as
+// "linux/*". Each Arch may be a glob pattern or "all", and may include a
+// variant (e.g. "arm/v7"). Arch is ignored for "first-class" and platform
+// patterns.
+//
+// Patterns only expand to supported platforms, but platforms listed explicitly
+// are returned even if unsupported so that they can be reported as skipped.
+// Platforms that were marked as skipped in SkipPlatforms are also returned,
+// and should be filtered out elsewhere.
+func (p Params) Platforms(dist []DistPlatform) ([]Platform, error) {
+	if len(p.OS) == 0 {
+		p.OS = OneOrMany{DefaultPlatform.OS}
+	}
+	if len(p.Arch) == 0 {
+		p.Arch = OneOrMany{DefaultPlatform.Arch}
+	}
+
+	var platforms []Platform
+	seen := map[Platform]bool{}
+	add := func(expanded []Platform) {
+		for _, platform := range expanded {
+			if !seen[platform] {
+				seen[platform] = true
+				platforms = append(platforms, platform)
+			}
+		}
+	}
+
+	for _, os := range p.OS {
+		switch {
+		case os == "first-class":
+			for _, d := range dist {
+				if d.FirstClass {
+					add([]Platform{d.Platform()})
+				}
+			}
+		case strings.Contains(os, "/"):
+			pattern, err := ParsePlatform(os)
+			if err != nil {
+				return nil, err
+			}
+			pattern.OS, pattern.Arch = presetPattern(pattern.OS), presetPattern(pattern.Arch)
+			add(expandPattern(dist, pattern))
+		default:
+			for _, arch := range p.Arch {
+				// arch may include a variant, e.g. "arm/v7"
+				arch, variant, _ := strings.Cut(arch, "/")
+				add(expandPattern(dist, Platform{
+					OS:      presetPattern(os),
+					Arch:    presetPattern(arch),
+					Variant: variant,
+				}))
+			}
+		}
+	}
+	return platforms, nil
+}
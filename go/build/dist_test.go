@@ -0,0 +1,83 @@
+package build
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlatforms(t *testing.T) {
+	var dist []DistPlatform
+	require.NoError(t, json.Unmarshal([]byte(`[
+		{"GOOS": "darwin", "GOARCH": "arm64", "FirstClass": true},
+		{"GOOS": "freebsd", "GOARCH": "amd64"},
+		{"GOOS": "linux", "GOARCH": "amd64", "FirstClass": true},
+		{"GOOS": "linux", "GOARCH": "arm", "FirstClass": true},
+		{"GOOS": "linux", "GOARCH": "riscv64"},
+		{"GOOS": "openbsd", "GOARCH": "amd64"},
+		{"GOOS": "openbsd", "GOARCH": "arm"}
+	]`), &dist))
+
+	DefaultPlatform = Platform{OS: "linux", Arch: "amd64"}
+
+	for _, tt := range []struct {
+		desc      string
+		os        OneOrMany
+		arch      OneOrMany
+		platforms []Platform
+	}{
+		{
+			desc:      "defaults",
+			platforms: []Platform{{OS: "linux", Arch: "amd64"}},
+		},
+		{
+			desc: "explicit platforms are kept even if unsupported",
+			os:   OneOrMany{"linux", "darwin"},
+			arch: OneOrMany{"amd64", "arm/v7"},
+			platforms: []Platform{
+				{OS: "linux", Arch: "amd64"},
+				{OS: "linux", Arch: "arm", Variant: "v7"},
+				{OS: "darwin", Arch: "amd64"},
+				{OS: "darwin", Arch: "arm", Variant: "v7"},
+			},
+		},
+		{
+			desc: "first-class",
+			os:   OneOrMany{"first-class"},
+			platforms: []Platform{
+				{OS: "darwin", Arch: "arm64"},
+				{OS: "linux", Arch: "amd64"},
+				{OS: "linux", Arch: "arm"},
+			},
+		},
+		{
+			desc: "all",
+			os:   OneOrMany{"all"},
+			arch: OneOrMany{"arm/v6"},
+			platforms: []Platform{
+				{OS: "linux", Arch: "arm", Variant: "v6"},
+				{OS: "openbsd", Arch: "arm", Variant: "v6"},
+			},
+		},
+		{
+			desc: "patterns",
+			os:   OneOrMany{"linux/*", "*bsd", "first-class"},
+			arch: OneOrMany{"amd64"},
+			platforms: []Platform{
+				{OS: "linux", Arch: "amd64"},
+				{OS: "linux", Arch: "arm"},
+				{OS: "linux", Arch: "riscv64"},
+				{OS: "freebsd", Arch: "amd64"},
+				{OS: "openbsd", Arch: "amd64"},
+				{OS: "darwin", Arch: "arm64"},
+			},
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			platforms, err := Params{OS: tt.os, Arch: tt.arch}.Platforms(dist)
+			require.NoError(t, err)
+			require.Equal(t, tt.platforms, platforms)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package build
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+
+	"github.com/aoldershaw/prototype-experiments/go/module"
+)
+
+// FailureClass describes why a build failed.
+type FailureClass string
+
+const (
+	FailureCompile   FailureClass = "compile"
+	FailureToolchain FailureClass = "toolchain"
+	FailureNetwork   FailureClass = "network"
+	FailureKilled    FailureClass = "killed"
+	FailureTimeout   FailureClass = "timeout"
+)
+
+// Transient returns whether a failure of this class may succeed if retried.
+func (c FailureClass) Transient() bool {
+	switch c {
+	case FailureNetwork, FailureKilled, FailureTimeout:
+		return true
+	}
+	return false
+}
+
+// toolchainErrors are substrings of go command output indicating that the
+// toolchain can't build for the platform or configuration.
+var toolchainErrors = []string{
+	"unsupported GOOS/GOARCH pair",
+	"requires go >= ",
+	"go: invalid GO",
+	"C compiler \"",
+	"cgo: C compiler",
+	"-race requires cgo",
+	"-race is not supported",
+}
+
+// networkErrors are substrings of go command output indicating that fetching
+// a module or toolchain (see "go: downloading go1.") failed, typically due to
+// a flaky connection or proxy. Generic errors such as "unexpected EOF" are
+// left out, as the compiler reports them for syntax errors too.
+var networkErrors = []string{
+	"dial tcp",
+	"i/o timeout",
+	"connection refused",
+	"connection reset by peer",
+	"TLS handshake timeout",
+	"no such host",
+	"Client.Timeout exceeded",
+	"502 Bad Gateway",
+	"503 Service Unavailable",
+	"504 Gateway Timeout",
+}
+
+func classifyFailure(err error, timedOut bool) FailureClass {
+	if timedOut {
+		return FailureTimeout
+	}
+
+	var execErr module.ExecutionError
+	if !errors.As(err, &execErr) {
+		return FailureCompile
+	}
+	// the kernel's OOM killer sends SIGKILL, either to the go command or to
+	// the compiler or linker it runs, in which case it reports e.g.
+	// "compile: signal: killed"
+	if execErr.Signal == syscall.SIGKILL || strings.Contains(execErr.Stderr, "signal: killed") {
+		return FailureKilled
+	}
+	for _, s := range toolchainErrors {
+		if strings.Contains(execErr.Stderr, s) {
+			return FailureToolchain
+		}
+	}
+	for _, s := range networkErrors {
+		if strings.Contains(execErr.Stderr, s) {
+			return FailureNetwork
+		}
+	}
+	return FailureCompile
+}
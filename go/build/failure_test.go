@@ -0,0 +1,70 @@
+package build
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+
+	"github.com/aoldershaw/prototype-experiments/go/module"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	for _, tt := range []struct {
+		desc     string
+		err      error
+		timedOut bool
+		class    FailureClass
+	}{
+		{
+			desc:  "compile error",
+			err:   module.ExecutionError{Err: errors.New("exit status 1"), Stderr: "./main.go:3:1: syntax error", ExitCode: 1},
+			class: FailureCompile,
+		},
+		{
+			desc:  "compile error with unexpected EOF",
+			err:   module.ExecutionError{Err: errors.New("exit status 1"), Stderr: "./main.go:3:1: syntax error: unexpected EOF, expected }", ExitCode: 1},
+			class: FailureCompile,
+		},
+		{
+			desc:  "unsupported platform",
+			err:   module.ExecutionError{Err: errors.New("exit status 2"), Stderr: "cmd/go: unsupported GOOS/GOARCH pair plan9/arm64", ExitCode: 2},
+			class: FailureToolchain,
+		},
+		{
+			desc:  "missing C compiler",
+			err:   module.ExecutionError{Err: errors.New("exit status 1"), Stderr: "cgo: C compiler \"gcc\" not found", ExitCode: 1},
+			class: FailureToolchain,
+		},
+		{
+			desc:  "module fetch",
+			err:   module.ExecutionError{Err: errors.New("exit status 1"), Stderr: "reading https://proxy.golang.org/example.com/a/@v/list: 503 Service Unavailable", ExitCode: 1},
+			class: FailureNetwork,
+		},
+		{
+			desc:  "killed",
+			err:   module.ExecutionError{Err: errors.New("signal: killed"), ExitCode: -1, Signal: syscall.SIGKILL},
+			class: FailureKilled,
+		},
+		{
+			desc:  "toolchain download",
+			err:   module.ExecutionError{Err: errors.New("exit status 1"), Stderr: "go: downloading go1.22.0 (linux/amd64)\ngo: download go1.22.0: golang.org/toolchain@v0.0.1-go1.22.0.linux-amd64.zip: dial tcp: lookup proxy.golang.org: no such host", ExitCode: 1},
+			class: FailureNetwork,
+		},
+		{
+			desc:  "compiler killed",
+			err:   module.ExecutionError{Err: errors.New("exit status 1"), Stderr: "# example.com/a\ncompile: signal: killed", ExitCode: 1},
+			class: FailureKilled,
+		},
+		{
+			desc:     "timeout",
+			err:      module.ExecutionError{Err: errors.New("signal: killed"), ExitCode: -1, Signal: syscall.SIGKILL},
+			timedOut: true,
+			class:    FailureTimeout,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			require.Equal(t, tt.class, classifyFailure(tt.err, tt.timedOut))
+		})
+	}
+}
@@ -3,22 +3,65 @@ package build
 import (
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/json"
 	"fmt"
 	"hash"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+	"lukechampine.com/blake3"
 )
 
 var hashers = map[string]func() hash.Hash{
 	"sha1":   sha1.New,
 	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"blake2b": func() hash.Hash {
+		// only fails for invalid keys
+		hasher, _ := blake2b.New512(nil)
+		return hasher
+	},
+	"blake3": func() hash.Hash {
+		return blake3.New(32, nil)
+	},
+}
+
+// Checksum modes select which checksum files are written: a sidecar file
+// next to each artifact (e.g. "def-linux-amd64.tar.gz.sha256"), a combined
+// file covering every artifact in the output directory (e.g. "SHA256SUMS"),
+// or both.
+const (
+	ChecksumSidecar  = "sidecar"
+	ChecksumCombined = "combined"
+	ChecksumBoth     = "both"
+)
+
+func validateChecksumMode(mode string) error {
+	switch mode {
+	case "", ChecksumSidecar, ChecksumCombined, ChecksumBoth:
+		return nil
+	}
+	return fmt.Errorf("invalid checksum mode %q (must be one of %s, %s or %s)", mode, ChecksumSidecar, ChecksumCombined, ChecksumBoth)
+}
+
+// defaultChecksumFile returns the default name of the combined checksum file
+// for the algorithm, e.g. "SHA256SUMS".
+func defaultChecksumFile(algorithm SHASum) string {
+	return strings.ToUpper(string(algorithm)) + "SUMS"
 }
 
 type SHASum string
 
+// DefaultSHASum is the algorithm of the combined checksum file if none is
+// given.
+const DefaultSHASum SHASum = "sha256"
+
 func (s *SHASum) UnmarshalJSON(data []byte) error {
 	{
 		var enabled bool
@@ -45,17 +88,11 @@ func (s *SHASum) UnmarshalJSON(data []byte) error {
 }
 
 func computeSHASum(file string, algorithm SHASum) error {
-	srcFile, err := os.Open(file)
+	digest, err := fileDigest(file, algorithm)
 	if err != nil {
-		return fmt.Errorf("failed to open output file for computing shasum: %w", err)
-	}
-	defer srcFile.Close()
-
-	hasher := hashers[string(algorithm)]()
-	if _, err := io.Copy(hasher, srcFile); err != nil {
-		return fmt.Errorf("failed to compute shasum: %w", err)
+		return err
 	}
-	sum := fmt.Sprintf("%x  %s", hasher.Sum(nil), filepath.Base(file))
+	sum := fmt.Sprintf("%s  %s", digest, filepath.Base(file))
 
 	outPath := file + "." + string(algorithm)
 	if err := ioutil.WriteFile(outPath, []byte(sum), 0755); err != nil {
@@ -64,3 +101,66 @@ func computeSHASum(file string, algorithm SHASum) error {
 
 	return nil
 }
+
+// writeChecksumFile writes a checksum file, in the format of sha256sum and
+// friends, covering every file in dir except for sidecar checksum files and
+// files with any of the ignored extensions (e.g. signatures). The paths of the
+// files are relative to dir, in lexical order.
+func writeChecksumFile(dir, name string, algorithm SHASum, ignoredExts ...string) error {
+	outPath := filepath.Join(dir, name)
+
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() || path == outPath || strings.HasSuffix(path, "."+string(algorithm)) {
+			return nil
+		}
+		for _, ext := range ignoredExts {
+			if strings.HasSuffix(path, ext) {
+				return nil
+			}
+		}
+		files = append(files, path)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list outputs: %w", err)
+	}
+
+	var sums []string
+	for _, file := range files {
+		digest, err := fileDigest(file, algorithm)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		sums = append(sums, fmt.Sprintf("%s  %s\n", digest, filepath.ToSlash(rel)))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outPath), 0755); err != nil {
+		return fmt.Errorf("failed to create checksum file directory: %w", err)
+	}
+	if err := ioutil.WriteFile(outPath, []byte(strings.Join(sums, "")), 0644); err != nil {
+		return fmt.Errorf("failed to write checksum file: %w", err)
+	}
+	return nil
+}
+
+func fileDigest(file string, algorithm SHASum) (string, error) {
+	srcFile, err := os.Open(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to open output file for computing shasum: %w", err)
+	}
+	defer srcFile.Close()
+
+	hasher := hashers[string(algorithm)]()
+	if _, err := io.Copy(hasher, srcFile); err != nil {
+		return "", fmt.Errorf("failed to compute shasum: %w", err)
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
@@ -0,0 +1,79 @@
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSHASumUnmarshal(t *testing.T) {
+	for _, tt := range []struct {
+		json   string
+		shasum SHASum
+		err    string
+	}{
+		{json: `true`, shasum: "sha1"},
+		{json: `false`, shasum: ""},
+		{json: `"sha512"`, shasum: "sha512"},
+		{json: `"blake2b"`, shasum: "blake2b"},
+		{json: `"blake3"`, shasum: "blake3"},
+		{json: `"md5"`, err: "invalid shasum algorithm: md5"},
+	} {
+		var shasum SHASum
+		err := json.Unmarshal([]byte(tt.json), &shasum)
+		if tt.err != "" {
+			require.EqualError(t, err, tt.err)
+		} else {
+			require.NoError(t, err)
+			require.Equal(t, tt.shasum, shasum)
+		}
+	}
+}
+
+func TestFileDigest(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "abc")
+	require.NoError(t, ioutil.WriteFile(file, []byte("abc"), 0644))
+
+	for algorithm, digest := range map[SHASum]string{
+		"sha1":    "a9993e364706816aba3e25717850c26c9cd0d89d",
+		"sha256":  "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		"sha512":  "ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f",
+		"blake2b": "ba80a53f981c4d0d6a2797b69f12f6e94c212f14685ac4b74b12bb6fdbffa2d17d87c5392aab792dc252d5de4533cc9518d38aa8dbf1925ab92386edd4009923",
+		"blake3":  "6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85",
+	} {
+		actual, err := fileDigest(file, algorithm)
+		require.NoError(t, err)
+		require.Equal(t, digest, actual, algorithm)
+	}
+}
+
+func TestWriteChecksumFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"def-linux-amd64.tar.gz":        "linux",
+		"def-linux-amd64.tar.gz.sha256": "sidecar",
+		"def-linux-amd64.tar.gz.asc":    "signature",
+		"v1.2.3/def-windows-amd64.zip":  "windows",
+	}
+	for name, contents := range files {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644))
+	}
+
+	require.NoError(t, writeChecksumFile(dir, "SHA256SUMS", "sha256", ".asc"))
+	// rewriting it doesn't include the previous checksum file
+	require.NoError(t, writeChecksumFile(dir, "SHA256SUMS", "sha256", ".asc"))
+
+	contents, err := ioutil.ReadFile(filepath.Join(dir, "SHA256SUMS"))
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("%x  def-linux-amd64.tar.gz\n%x  v1.2.3/def-windows-amd64.zip\n",
+		sha256.Sum256([]byte("linux")),
+		sha256.Sum256([]byte("windows")),
+	), string(contents))
+}
@@ -0,0 +1,318 @@
+package build
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"time"
+)
+
+// MatrixEntry selects builds in the matrix by platform, package and/or
+// variant, and overrides their options. Each may be a glob pattern (e.g.
+// "windows/*" or "github.com/abc/def/cmd/*"), and the package may be given
+// either as an import path or as the name of its directory. Unset fields match
+// every build.
+type MatrixEntry struct {
+	Platform *Platform `json:"platform"`
+	Package  string    `json:"package"`
+	Variant  string    `json:"variant"`
+
+	Overrides
+}
+
+// Variant is a named set of options. Every package and platform in the matrix
+// is built once for each variant.
+type Variant struct {
+	Name string `json:"name"`
+
+	Overrides
+}
+
+// Overrides are options that may be set for a subset of the builds, with
+// unset fields left as they are. Options that aren't listed here (such as
+// offline, reproducible, the checksums and signing) apply to every build.
+type Overrides struct {
+	OutputTemplate *string `json:"output_template"`
+
+	Ldflags  *string `json:"ldflags"`
+	Gcflags  *string `json:"gcflags"`
+	Asmflags *string `json:"asmflags"`
+
+	Tags     []string          `json:"tags"`
+	ModMode  *string           `json:"mod"`
+	Rebuild  *bool             `json:"rebuild"`
+	Race     *bool             `json:"race"`
+	Cgo      *bool             `json:"cgo"`
+	BuildVCS *bool             `json:"buildvcs"`
+	Env      map[string]string `json:"env"`
+
+	Timeout *Duration `json:"timeout"`
+	Retries *int      `json:"retries"`
+
+	ArchiveFiles     []string `json:"archive_files"`
+	ArchiveFormat    *string  `json:"archive_format"`
+	CompressionLevel *int     `json:"compression_level"`
+}
+
+// Matches returns whether the build identified by id is selected by e.
+func (e MatrixEntry) Matches(id ID) bool {
+	if e.Platform != nil && !e.Platform.Match(id.Platform) {
+		return false
+	}
+	if e.Package != "" {
+		importPathMatch, _ := path.Match(e.Package, id.Package)
+		dirMatch, _ := path.Match(e.Package, path.Base(id.Package))
+		if !importPathMatch && !dirMatch {
+			return false
+		}
+	}
+	if e.Variant != "" {
+		if variantMatch, _ := path.Match(e.Variant, id.Variant); !variantMatch {
+			return false
+		}
+	}
+	return true
+}
+
+func (e Overrides) apply(opts *Options, outputTemplate *string) {
+	if e.OutputTemplate != nil {
+		*outputTemplate = *e.OutputTemplate
+	}
+	if e.Ldflags != nil {
+		opts.Ldflags = *e.Ldflags
+	}
+	if e.Gcflags != nil {
+		opts.Gcflags = *e.Gcflags
+	}
+	if e.Asmflags != nil {
+		opts.Asmflags = *e.Asmflags
+	}
+	if e.Tags != nil {
+		opts.Tags = e.Tags
+	}
+	if e.ModMode != nil {
+		opts.ModMode = *e.ModMode
+	}
+	if e.Rebuild != nil {
+		opts.Rebuild = *e.Rebuild
+	}
+	if e.Race != nil {
+		opts.Race = *e.Race
+	}
+	if e.Cgo != nil {
+		opts.Cgo = *e.Cgo
+	}
+	if e.BuildVCS != nil {
+		opts.BuildVCS = e.BuildVCS
+	}
+	if e.Timeout != nil {
+		opts.Timeout = time.Duration(*e.Timeout)
+	}
+	if e.Retries != nil {
+		opts.Retries = *e.Retries
+	}
+	if e.ArchiveFiles != nil {
+		opts.ArchiveFiles = e.ArchiveFiles
+	}
+	if e.ArchiveFormat != nil {
+		opts.ArchiveFormat = *e.ArchiveFormat
+	}
+	if e.CompressionLevel != nil {
+		opts.CompressionLevel = *e.CompressionLevel
+	}
+
+	var keys []string
+	for k := range e.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		opts.Env = append(opts.Env, k+"="+e.Env[k])
+	}
+}
+
+// matrix returns the builds to run: the cross product of packages and
+// platforms, plus any platforms added by include entries. Builds that are
+// skipped or excluded are still returned, and should be filtered out
+// elsewhere.
+func (p Params) matrix(packages []string, platforms []Platform) []ID {
+	variants := []string{""}
+	if len(p.Variants) > 0 {
+		variants = nil
+		for _, variant := range p.Variants {
+			variants = append(variants, variant.Name)
+		}
+	}
+
+	var ids []ID
+	for _, pkg := range packages {
+		for _, variant := range variants {
+			seen := map[Platform]bool{}
+			for _, platform := range platforms {
+				seen[platform] = true
+				ids = append(ids, ID{Platform: platform, Package: pkg, Variant: variant})
+			}
+
+			// an include entry with a specific platform adds it to the
+			// matrix (e.g. to build for linux/arm64 only, but with cgo
+			// enabled)
+			for _, entry := range p.Include {
+				if entry.Platform == nil || entry.Platform.isPattern() || seen[*entry.Platform] {
+					continue
+				}
+				id := ID{Platform: *entry.Platform, Package: pkg, Variant: variant}
+				if entry.Matches(id) {
+					seen[*entry.Platform] = true
+					ids = append(ids, id)
+				}
+			}
+		}
+	}
+	return ids
+}
+
+func (p Params) validateVariants() error {
+	seen := map[string]bool{}
+	for _, variant := range p.Variants {
+		if variant.Name == "" {
+			return fmt.Errorf("variants must have a name")
+		}
+		if seen[variant.Name] {
+			return fmt.Errorf("duplicate variant %q", variant.Name)
+		}
+		seen[variant.Name] = true
+	}
+	return nil
+}
+
+func (p Params) excluded(id ID) bool {
+	for _, entry := range p.Exclude {
+		if entry.Matches(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// options returns the options for the build identified by id, along with its
+// output template. The top-level params apply to every build, and are
+// overridden by the platform-specific flags, then the build's variant, and
+// then by each matching include entry in turn.
+func (p Params) options(id ID) (Options, string) {
+	opts := Options{
+		ID: id,
+
+		Archive:          p.Archive,
+		ArchiveFiles:     platformValue(id.Platform, p.ArchiveFiles, p.PlatformArchiveFiles),
+		ArchiveFormat:    platformValue(id.Platform, p.ArchiveFormat, p.PlatformArchiveFormat),
+		CompressionLevel: p.CompressionLevel,
+		SHASum:           p.sidecarSHASum(),
+
+		Ldflags:  platformValue(id.Platform, p.Ldflags, p.PlatformLdflags),
+		Gcflags:  platformValue(id.Platform, p.Gcflags, p.PlatformGcflags),
+		Asmflags: platformValue(id.Platform, p.Asmflags, p.PlatformAsmflags),
+		Tags:     p.Tags,
+		ModMode:  p.ModMode,
+		Rebuild:  p.Rebuild,
+		Race:     p.Race,
+		Cgo:      p.Cgo,
+		Offline:  p.Offline,
+		Trimpath: p.Reproducible,
+		BuildVCS: p.BuildVCS,
+
+		Timeout: time.Duration(p.Timeout),
+		Retries: p.Retries,
+
+		VerifyReproducible: p.VerifyReproducible,
+	}
+	outputTemplate := p.OutputTemplate
+	for _, variant := range p.Variants {
+		if variant.Name == id.Variant {
+			variant.apply(&opts, &outputTemplate)
+		}
+	}
+	for _, entry := range p.Include {
+		if entry.Matches(id) {
+			entry.apply(&opts, &outputTemplate)
+		}
+	}
+
+	switch {
+	case opts.ArchiveFormat == NoArchive:
+		opts.Archive = false
+	case opts.ArchiveFormat == "" && id.Platform.OS == "windows":
+		opts.ArchiveFormat = "zip"
+	case opts.ArchiveFormat == "":
+		opts.ArchiveFormat = "tar.gz"
+	}
+	return opts, outputTemplate
+}
+
+// sidecarSHASum returns the algorithm for sidecar checksum files, if they're
+// enabled.
+func (p Params) sidecarSHASum() SHASum {
+	if p.ChecksumMode == ChecksumCombined {
+		return ""
+	}
+	return p.SHASum
+}
+
+// validateArchiveFormats returns an error if any of the archive formats are
+// unsupported.
+func (p Params) validateArchiveFormats() error {
+	formats := []string{p.ArchiveFormat}
+	for _, format := range p.PlatformArchiveFormat {
+		formats = append(formats, format)
+	}
+	for _, variant := range p.Variants {
+		if variant.ArchiveFormat != nil {
+			formats = append(formats, *variant.ArchiveFormat)
+		}
+	}
+	for _, entry := range p.Include {
+		if entry.ArchiveFormat != nil {
+			formats = append(formats, *entry.ArchiveFormat)
+		}
+	}
+	for _, format := range formats {
+		if format == "" {
+			continue
+		}
+		if err := validateArchiveFormat(format); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// platformValue returns the override for the platform, if any, and otherwise
+// value. Overrides for a platform without a variant apply to all its
+// variants, and overrides for "<os>/*" apply to every platform of the OS.
+func platformValue[T any](platform Platform, value T, overrides map[Platform]T) T {
+	if override, ok := overrides[platform]; ok {
+		return override
+	}
+	if override, ok := overrides[platform.WithoutVariant()]; ok {
+		return override
+	}
+	if override, ok := overrides[Platform{OS: platform.OS, Arch: "*"}]; ok {
+		return override
+	}
+	return value
+}
+
+// outputTemplates returns every output template that may be used by a build.
+func (p Params) outputTemplates() []string {
+	templates := []string{p.OutputTemplate}
+	for _, variant := range p.Variants {
+		if variant.OutputTemplate != nil {
+			templates = append(templates, *variant.OutputTemplate)
+		}
+	}
+	for _, entry := range p.Include {
+		if entry.OutputTemplate != nil {
+			templates = append(templates, *entry.OutputTemplate)
+		}
+	}
+	return templates
+}
@@ -0,0 +1,96 @@
+package build
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatrixEntryMatches(t *testing.T) {
+	for _, tt := range []struct {
+		entry   string
+		id      ID
+		matches bool
+	}{
+		{
+			entry:   `{}`,
+			id:      ID{Platform: Platform{OS: "linux", Arch: "amd64"}, Package: "github.com/abc/def"},
+			matches: true,
+		},
+		{
+			entry:   `{"platform": "windows/*"}`,
+			id:      ID{Platform: Platform{OS: "windows", Arch: "arm64"}, Package: "github.com/abc/def"},
+			matches: true,
+		},
+		{
+			entry:   `{"platform": "windows/*"}`,
+			id:      ID{Platform: Platform{OS: "linux", Arch: "arm64"}, Package: "github.com/abc/def"},
+			matches: false,
+		},
+		{
+			entry:   `{"platform": "*/arm*", "package": "github.com/abc/*"}`,
+			id:      ID{Platform: Platform{OS: "linux", Arch: "arm64"}, Package: "github.com/abc/def"},
+			matches: true,
+		},
+		{
+			entry:   `{"package": "def"}`,
+			id:      ID{Platform: Platform{OS: "linux", Arch: "arm64"}, Package: "github.com/abc/def"},
+			matches: true,
+		},
+		{
+			entry:   `{"platform": "linux/arm"}`,
+			id:      ID{Platform: Platform{OS: "linux", Arch: "arm", Variant: "v7"}, Package: "github.com/abc/def"},
+			matches: true,
+		},
+		{
+			entry:   `{"platform": "linux/arm/v6"}`,
+			id:      ID{Platform: Platform{OS: "linux", Arch: "arm", Variant: "v7"}, Package: "github.com/abc/def"},
+			matches: false,
+		},
+		{
+			entry:   `{"package": "def"}`,
+			id:      ID{Platform: Platform{OS: "linux", Arch: "arm64"}, Package: "github.com/abc/def/cmd/other"},
+			matches: false,
+		},
+	} {
+		var entry MatrixEntry
+		require.NoError(t, json.Unmarshal([]byte(tt.entry), &entry))
+		require.Equal(t, tt.matches, entry.Matches(tt.id), "%s matches %s", tt.entry, tt.id)
+	}
+}
+
+func TestOptionsOverrides(t *testing.T) {
+	var entry MatrixEntry
+	err := json.Unmarshal([]byte(`{
+		"platform": "windows/*",
+		"rebuild": true,
+		"buildvcs": false,
+		"timeout": "30m",
+		"retries": 2,
+		"compression_level": 9
+	}`), &entry)
+	require.NoError(t, err)
+
+	params := Params{
+		Timeout:          Duration(10 * time.Minute),
+		Retries:          1,
+		CompressionLevel: 1,
+		Include:          []MatrixEntry{entry},
+	}
+
+	opts, _ := params.options(ID{Platform: Platform{OS: "windows", Arch: "amd64"}})
+	require.True(t, opts.Rebuild)
+	require.Equal(t, boolPtr(false), opts.BuildVCS)
+	require.Equal(t, 30*time.Minute, opts.Timeout)
+	require.Equal(t, 2, opts.Retries)
+	require.Equal(t, 9, opts.CompressionLevel)
+
+	opts, _ = params.options(ID{Platform: Platform{OS: "linux", Arch: "amd64"}})
+	require.False(t, opts.Rebuild)
+	require.Nil(t, opts.BuildVCS)
+	require.Equal(t, 10*time.Minute, opts.Timeout)
+	require.Equal(t, 1, opts.Retries)
+	require.Equal(t, 1, opts.CompressionLevel)
+}
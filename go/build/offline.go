@@ -0,0 +1,255 @@
+package build
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aoldershaw/prototype-experiments/go/module"
+	"golang.org/x/mod/modfile"
+)
+
+// offlineEnv is the environment that prevents the go command from accessing
+// the network, either to download modules or toolchains.
+var offlineEnv = []string{
+	"GOPROXY=off",
+	"GOTOOLCHAIN=local",
+}
+
+// offlineModule runs every command in the module with offlineEnv, including
+// those used to resolve packages, platforms and the module itself.
+type offlineModule struct {
+	Module
+}
+
+func (m offlineModule) Execute(cmd *exec.Cmd) error {
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, offlineEnv...)
+	return m.Module.Execute(cmd)
+}
+
+func (m offlineModule) ResolvePackages(packages ...string) ([]module.Package, error) {
+	return module.ResolvePackages(m, packages...)
+}
+
+func (m offlineModule) Info() (module.Info, error) {
+	return module.ReadInfo(m)
+}
+
+var lookupDisabledRegexp = regexp.MustCompile(`(\S+@[^\s:]+): module lookup disabled`)
+
+// checkOffline ensures that every dependency of the module is available
+// without network access, and returns the -mod mode the builds should use.
+// mod must be an offlineModule.
+// If the module has a vendor directory, it must be consistent with go.mod.
+// Otherwise, every module needed for the build must be in the module cache.
+func checkOffline(mod Module, modMode, gopathDir, gocacheDir string) (string, error) {
+	info, err := mod.Info()
+	if err != nil {
+		return "", fmt.Errorf("failed to get module info: %w", err)
+	}
+
+	if modMode == "" {
+		modMode = "readonly"
+		if _, err := os.Stat(filepath.Join(info.Dir, "vendor", "modules.txt")); err == nil {
+			modMode = "vendor"
+		}
+	}
+
+	switch modMode {
+	case "vendor":
+		return modMode, checkVendor(info.Dir)
+	case "readonly":
+		return modMode, checkModuleCache(mod, gopathDir, gocacheDir)
+	default:
+		return "", fmt.Errorf("offline mode requires mod to be vendor or readonly, got %q", modMode)
+	}
+}
+
+// checkModuleCache reports any modules needed to build the main module that
+// are missing from the module cache.
+func checkModuleCache(mod Module, gopathDir, gocacheDir string) error {
+	var stdout bytes.Buffer
+	cmd := exec.Command("go", "mod", "download", "-json")
+	cmd.Env = []string{
+		"GOPATH=" + gopathDir,
+		"GOCACHE=" + gocacheDir,
+		"GOFLAGS=-mod=readonly",
+	}
+	cmd.Stdout = &stdout
+	runErr := mod.Execute(cmd)
+
+	versions, err := module.ParseVersions(&stdout)
+	if err != nil {
+		return fmt.Errorf("failed to parse go mod download output: %w", err)
+	}
+
+	var missing []string
+	for _, v := range versions {
+		if v.Error != "" {
+			missing = append(missing, v.String())
+		}
+	}
+	// if the module graph can't be loaded (e.g. a go.mod file is missing from
+	// the cache), the error is only reported on stderr
+	var execErr module.ExecutionError
+	if len(missing) == 0 && errors.As(runErr, &execErr) {
+		for _, match := range lookupDisabledRegexp.FindAllStringSubmatch(execErr.Stderr, -1) {
+			missing = append(missing, match[1])
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing from module cache: %s", strings.Join(missing, ", "))
+	}
+	if runErr != nil {
+		return fmt.Errorf("failed to check module cache: %w", runErr)
+	}
+	return nil
+}
+
+// vendoredModule is an entry in vendor/modules.txt.
+type vendoredModule struct {
+	version     module.Version
+	replacement string
+	explicit    bool
+	packages    []string
+}
+
+// checkVendor reports inconsistencies between go.mod and vendor/modules.txt,
+// and vendored packages that are missing from the vendor directory.
+func checkVendor(dir string) error {
+	goMod, err := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return fmt.Errorf("failed to read go.mod: %w", err)
+	}
+	modFile, err := modfile.Parse("go.mod", goMod, nil)
+	if err != nil {
+		return fmt.Errorf("failed to parse go.mod: %w", err)
+	}
+
+	modulesTxt, err := ioutil.ReadFile(filepath.Join(dir, "vendor", "modules.txt"))
+	if err != nil {
+		return fmt.Errorf("failed to read vendor/modules.txt: %w", err)
+	}
+	vendored := parseModulesTxt(modulesTxt)
+
+	replacements := map[string]string{}
+	for _, r := range modFile.Replace {
+		old := r.Old.Path
+		if r.Old.Version != "" {
+			old += " " + r.Old.Version
+		}
+		replacement := r.New.Path
+		if r.New.Version != "" {
+			replacement += " " + r.New.Version
+		}
+		replacements[old] = replacement
+	}
+
+	var problems []string
+	required := map[string]bool{}
+	for _, r := range modFile.Require {
+		required[r.Mod.Path] = true
+		want := module.Version{Path: r.Mod.Path, Version: r.Mod.Version}
+
+		vm, ok := vendored[r.Mod.Path]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s is not vendored", want))
+			continue
+		case vm.version.Version != want.Version:
+			problems = append(problems, fmt.Sprintf("%s is vendored at %s", want, vm.version.Version))
+		case !vm.explicit:
+			problems = append(problems, fmt.Sprintf("%s is not marked as explicit in vendor/modules.txt", want))
+		}
+
+		replacement, ok := replacements[r.Mod.Path+" "+r.Mod.Version]
+		if !ok {
+			replacement = replacements[r.Mod.Path]
+		}
+		if vm.replacement != replacement {
+			problems = append(problems, fmt.Sprintf("%s is replaced by %q in go.mod, but %q in vendor/modules.txt", want, replacement, vm.replacement))
+		}
+
+		for _, pkg := range vm.packages {
+			if _, err := os.Stat(filepath.Join(dir, "vendor", filepath.FromSlash(pkg))); err != nil {
+				problems = append(problems, fmt.Sprintf("%s is missing package %s from vendor directory", want, pkg))
+				break
+			}
+		}
+	}
+	var vendoredPaths []string
+	for path := range vendored {
+		vendoredPaths = append(vendoredPaths, path)
+	}
+	sort.Strings(vendoredPaths)
+	for _, path := range vendoredPaths {
+		if vm := vendored[path]; vm.explicit && !required[path] {
+			problems = append(problems, fmt.Sprintf("%s is vendored but not required in go.mod", vm.version))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("vendor/modules.txt is inconsistent with go.mod:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// parseModulesTxt parses vendor/modules.txt, which consists of module lines
+// ("# path version [=> replacement]"), annotations ("## explicit; go 1.21")
+// and the vendored packages of each module.
+func parseModulesTxt(data []byte) map[string]*vendoredModule {
+	modules := map[string]*vendoredModule{}
+	var cur *vendoredModule
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "## "):
+			if cur == nil {
+				continue
+			}
+			for _, annotation := range strings.Split(strings.TrimPrefix(line, "## "), ";") {
+				if strings.TrimSpace(annotation) == "explicit" {
+					cur.explicit = true
+				}
+			}
+		case strings.HasPrefix(line, "# "):
+			spec := strings.TrimPrefix(line, "# ")
+			var replacement string
+			if i := strings.Index(spec, " => "); i >= 0 {
+				spec, replacement = spec[:i], spec[i+len(" => "):]
+			}
+			fields := strings.Fields(spec)
+			if len(fields) == 0 {
+				cur = nil
+				continue
+			}
+			cur = &vendoredModule{
+				version:     module.Version{Path: fields[0]},
+				replacement: replacement,
+			}
+			if len(fields) > 1 {
+				cur.version.Version = fields[1]
+			}
+			// wildcard replacements ("# path => replacement") don't correspond
+			// to a vendored module themselves
+			if len(fields) > 1 {
+				modules[cur.version.Path] = cur
+			}
+		case line != "" && cur != nil:
+			cur.packages = append(cur.packages, line)
+		}
+	}
+	return modules
+}
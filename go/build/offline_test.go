@@ -0,0 +1,110 @@
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeVendoredModule creates a module directory with the given go.mod and
+// vendor/modules.txt, along with the vendored packages listed in modules.txt.
+func writeVendoredModule(t *testing.T, goMod, modulesTxt string) string {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "vendor"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte(goMod), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "vendor", "modules.txt"), []byte(modulesTxt), 0644))
+	for _, vm := range parseModulesTxt([]byte(modulesTxt)) {
+		for _, pkg := range vm.packages {
+			require.NoError(t, os.MkdirAll(filepath.Join(dir, "vendor", pkg), 0755))
+		}
+	}
+	return dir
+}
+
+func TestCheckVendor(t *testing.T) {
+	const goMod = `module github.com/abc/def
+
+go 1.21
+
+require (
+	example.com/a v1.0.0
+	example.com/b v1.2.0
+)
+
+require example.com/indirect v0.1.0 // indirect
+
+replace example.com/b => ../b
+`
+
+	for _, tt := range []struct {
+		desc       string
+		modulesTxt string
+		remove     string
+		err        string
+	}{
+		{
+			desc: "consistent",
+			modulesTxt: `# example.com/a v1.0.0
+## explicit; go 1.21
+example.com/a
+example.com/a/sub
+# example.com/b v1.2.0 => ../b
+## explicit
+example.com/b
+# example.com/indirect v0.1.0
+## explicit; go 1.18
+# example.com/b => ../b
+`,
+		},
+		{
+			desc: "inconsistent",
+			modulesTxt: `# example.com/a v0.9.0
+## explicit; go 1.21
+example.com/a
+# example.com/b v1.2.0
+## explicit
+example.com/b
+# example.com/extra v1.0.0
+## explicit
+`,
+			err: `vendor/modules.txt is inconsistent with go.mod:
+  example.com/a@v1.0.0 is vendored at v0.9.0
+  example.com/b@v1.2.0 is replaced by "../b" in go.mod, but "" in vendor/modules.txt
+  example.com/indirect@v0.1.0 is not vendored
+  example.com/extra@v1.0.0 is vendored but not required in go.mod`,
+		},
+		{
+			desc: "missing package",
+			modulesTxt: `# example.com/a v1.0.0
+## explicit; go 1.21
+example.com/a
+example.com/a/sub
+# example.com/b v1.2.0 => ../b
+## explicit
+example.com/b
+# example.com/indirect v0.1.0
+## explicit; go 1.18
+`,
+			remove: "example.com/a/sub",
+			err: `vendor/modules.txt is inconsistent with go.mod:
+  example.com/a@v1.0.0 is missing package example.com/a/sub from vendor directory`,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			dir := writeVendoredModule(t, goMod, tt.modulesTxt)
+			if tt.remove != "" {
+				require.NoError(t, os.RemoveAll(filepath.Join(dir, "vendor", tt.remove)))
+			}
+
+			err := checkVendor(dir)
+			if tt.err != "" {
+				require.EqualError(t, err, tt.err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
@@ -3,31 +3,83 @@ package build
 import (
 	"encoding/json"
 	"fmt"
+	"path"
 	"strings"
 )
 
 type Platform struct {
 	OS   string
 	Arch string
+	// Variant is the optional architecture variant, e.g. "v7" for
+	// linux/arm/v7 or "v3" for linux/amd64/v3.
+	Variant string
+}
+
+// variantEnv are the environment variables that set the variant of each
+// architecture.
+var variantEnv = map[string]string{
+	"386":      "GO386",
+	"amd64":    "GOAMD64",
+	"arm":      "GOARM",
+	"arm64":    "GOARM64",
+	"mips":     "GOMIPS",
+	"mipsle":   "GOMIPS",
+	"mips64":   "GOMIPS64",
+	"mips64le": "GOMIPS64",
+	"ppc64":    "GOPPC64",
+	"ppc64le":  "GOPPC64",
+	"riscv64":  "GORISCV64",
+	"wasm":     "GOWASM",
+}
+
+func ParsePlatform(str string) (Platform, error) {
+	parts := strings.Split(str, "/")
+	switch len(parts) {
+	case 2:
+		return Platform{OS: parts[0], Arch: parts[1]}, nil
+	case 3:
+		return Platform{OS: parts[0], Arch: parts[1], Variant: parts[2]}, nil
+	}
+	return Platform{}, fmt.Errorf("platform should be of the form \"<os>/<arch>[/<variant>]\" (e.g. \"linux/amd64\" or \"linux/arm/v7\")")
 }
 
 func (p Platform) String() string {
+	if p.Variant != "" {
+		return p.OS + "/" + p.Arch + "/" + p.Variant
+	}
 	return p.OS + "/" + p.Arch
 }
 
+// Env returns the environment variable that selects the platform's variant,
+// if any.
+func (p Platform) Env() ([]string, error) {
+	if p.Variant == "" {
+		return nil, nil
+	}
+	name, ok := variantEnv[p.Arch]
+	if !ok {
+		return nil, fmt.Errorf("variants are not supported for %s", p.Arch)
+	}
+	value := p.Variant
+	if p.Arch == "arm" {
+		// GOARM is just the version number (e.g. 7), optionally followed by
+		// ",softfloat" or ",hardfloat"
+		value = strings.TrimPrefix(value, "v")
+	}
+	return []string{name + "=" + value}, nil
+}
+
+// WithoutVariant returns the platform with the variant removed.
+func (p Platform) WithoutVariant() Platform {
+	return Platform{OS: p.OS, Arch: p.Arch}
+}
+
 func (p *Platform) UnmarshalJSON(data []byte) error {
 	var dst string
 	if err := json.Unmarshal(data, &dst); err != nil {
 		return err
 	}
-
-	parts := strings.Split(dst, "/")
-	if len(parts) != 2 {
-		return fmt.Errorf("platform should be of the form \"<os>/<arch>\" (e.g. \"linux/amd64\")")
-	}
-
-	*p = Platform{OS: parts[0], Arch: parts[1]}
-	return nil
+	return p.UnmarshalText([]byte(dst))
 }
 
 func (p Platform) MarshalJSON() ([]byte, error) {
@@ -35,16 +87,39 @@ func (p Platform) MarshalJSON() ([]byte, error) {
 }
 
 func (p *Platform) UnmarshalText(data []byte) error {
-	return json.Unmarshal(data, p)
+	platform, err := ParsePlatform(string(data))
+	if err != nil {
+		return err
+	}
+	*p = platform
+	return nil
 }
 
 func (p Platform) MarshalText() ([]byte, error) {
-	return json.Marshal(p.String())
+	return []byte(p.String()), nil
+}
+
+// Match returns whether platform matches p, which may contain glob patterns
+// (as in path.Match) in any of its parts. If p has no variant, it matches
+// every variant of its architecture.
+func (p Platform) Match(platform Platform) bool {
+	osMatch, _ := path.Match(p.OS, platform.OS)
+	archMatch, _ := path.Match(p.Arch, platform.Arch)
+	variantMatch := true
+	if p.Variant != "" {
+		variantMatch, _ = path.Match(p.Variant, platform.Variant)
+	}
+	return osMatch && archMatch && variantMatch
+}
+
+// isPattern returns whether p contains any glob patterns.
+func (p Platform) isPattern() bool {
+	return strings.ContainsAny(p.String(), `*?[\`)
 }
 
-func containsPlatform(platforms []Platform, platform Platform) bool {
-	for _, p := range platforms {
-		if platform == p {
+func matchesAnyPlatform(patterns []Platform, platform Platform) bool {
+	for _, p := range patterns {
+		if p.Match(platform) {
 			return true
 		}
 	}
@@ -0,0 +1,63 @@
+package build
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlatformJSON(t *testing.T) {
+	var params struct {
+		Platforms []Platform          `json:"platforms"`
+		Overrides map[Platform]string `json:"overrides"`
+	}
+	err := json.Unmarshal([]byte(`{
+		"platforms": ["linux/amd64", "linux/arm/v7", "windows/*"],
+		"overrides": {"linux/arm64": "a", "linux/amd64/v3": "b"}
+	}`), &params)
+	require.NoError(t, err)
+
+	require.Equal(t, []Platform{
+		{OS: "linux", Arch: "amd64"},
+		{OS: "linux", Arch: "arm", Variant: "v7"},
+		{OS: "windows", Arch: "*"},
+	}, params.Platforms)
+	require.Equal(t, map[Platform]string{
+		{OS: "linux", Arch: "arm64"}:                "a",
+		{OS: "linux", Arch: "amd64", Variant: "v3"}: "b",
+	}, params.Overrides)
+
+	payload, err := json.Marshal(params)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"platforms": ["linux/amd64", "linux/arm/v7", "windows/*"],
+		"overrides": {"linux/arm64": "a", "linux/amd64/v3": "b"}
+	}`, string(payload))
+
+	var platform Platform
+	require.Error(t, json.Unmarshal([]byte(`"linux"`), &platform))
+}
+
+func TestPlatformEnv(t *testing.T) {
+	for _, tt := range []struct {
+		platform Platform
+		env      []string
+		err      string
+	}{
+		{platform: Platform{OS: "linux", Arch: "amd64"}},
+		{platform: Platform{OS: "linux", Arch: "arm", Variant: "v7"}, env: []string{"GOARM=7"}},
+		{platform: Platform{OS: "linux", Arch: "arm", Variant: "6,softfloat"}, env: []string{"GOARM=6,softfloat"}},
+		{platform: Platform{OS: "linux", Arch: "amd64", Variant: "v3"}, env: []string{"GOAMD64=v3"}},
+		{platform: Platform{OS: "linux", Arch: "mipsle", Variant: "softfloat"}, env: []string{"GOMIPS=softfloat"}},
+		{platform: Platform{OS: "linux", Arch: "s390x", Variant: "z13"}, err: "variants are not supported for s390x"},
+	} {
+		env, err := tt.platform.Env()
+		if tt.err != "" {
+			require.EqualError(t, err, tt.err)
+		} else {
+			require.NoError(t, err)
+			require.Equal(t, tt.env, env, tt.platform.String())
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package build
+
+import "os/exec"
+
+// killProcessGroup is a no-op on platforms without process groups - only the
+// go command itself is killed when cmd's context is cancelled.
+func killProcessGroup(cmd *exec.Cmd) {}
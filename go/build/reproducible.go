@@ -0,0 +1,88 @@
+package build
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// sourceDateEpoch returns the time given by the SOURCE_DATE_EPOCH environment
+// variable (see https://reproducible-builds.org/specs/source-date-epoch/), or
+// the zero time if it isn't set.
+func sourceDateEpoch() (time.Time, error) {
+	value := os.Getenv("SOURCE_DATE_EPOCH")
+	if value == "" {
+		return time.Time{}, nil
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH: %w", err)
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+// verifyReproducible builds the binary again with an empty build cache (and
+// without the remote cache), and returns an error if it differs from the
+// binary at binaryPath.
+func verifyReproducible(ctx context.Context, mod Module, opts Options, binaryPath string) (FailureClass, error) {
+	tmpDir, err := os.MkdirTemp(opts.TempDir, "verify")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	opts.Gocache = filepath.Join(tmpDir, "cache")
+	opts.CacheProg = ""
+	verifyPath := filepath.Join(tmpDir, filepath.Base(binaryPath))
+
+	buildCtx, cancel := ctx, context.CancelFunc(func() {})
+	if opts.Timeout > 0 {
+		buildCtx, cancel = context.WithTimeout(ctx, opts.Timeout)
+	}
+	defer cancel()
+
+	cmd, err := buildCommand(buildCtx, opts, verifyPath)
+	if err != nil {
+		return "", err
+	}
+	if err := mod.Execute(cmd); err != nil {
+		timedOut := errors.Is(buildCtx.Err(), context.DeadlineExceeded)
+		if timedOut {
+			err = fmt.Errorf("timed out after %s", opts.Timeout)
+		}
+		return classifyFailure(err, timedOut), fmt.Errorf("failed to rebuild for verification: %w", err)
+	}
+
+	expected, err := hashFile(binaryPath)
+	if err != nil {
+		return "", err
+	}
+	actual, err := hashFile(verifyPath)
+	if err != nil {
+		return "", err
+	}
+	if expected != actual {
+		return "", fmt.Errorf("build is not reproducible: sha256 %s differs from %s when rebuilt with an empty cache", actual, expected)
+	}
+	return "", nil
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open output file for hashing: %w", err)
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", fmt.Errorf("failed to hash output file: %w", err)
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
@@ -0,0 +1,87 @@
+package build
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSourceDateEpoch(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+	modTime, err := sourceDateEpoch()
+	require.NoError(t, err)
+	require.True(t, modTime.IsZero())
+
+	t.Setenv("SOURCE_DATE_EPOCH", "1620345600")
+	modTime, err = sourceDateEpoch()
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2021, 5, 7, 0, 0, 0, 0, time.UTC), modTime)
+
+	t.Setenv("SOURCE_DATE_EPOCH", "yesterday")
+	_, err = sourceDateEpoch()
+	require.EqualError(t, err, `invalid SOURCE_DATE_EPOCH: strconv.ParseInt: parsing "yesterday": invalid syntax`)
+}
+
+func TestVerifyReproducible(t *testing.T) {
+	outputDir := t.TempDir()
+	binaryPath := filepath.Join(outputDir, "def")
+
+	opts := Options{
+		ID:        ID{Platform: Platform{OS: "linux", Arch: "amd64"}, Package: "github.com/abc/def"},
+		Gopath:    "/gopath",
+		Gocache:   "/gocache",
+		CacheProg: "prog",
+		Trimpath:  true,
+	}
+
+	for _, tt := range []struct {
+		desc   string
+		binary func(cmd *exec.Cmd) string
+		err    string
+	}{
+		{
+			desc: "reproducible",
+			binary: func(cmd *exec.Cmd) string {
+				return "binary"
+			},
+		},
+		{
+			desc: "depends on cache",
+			binary: func(cmd *exec.Cmd) string {
+				for _, env := range cmd.Env {
+					if strings.HasPrefix(env, "GOCACHE=") {
+						return env
+					}
+				}
+				return ""
+			},
+			err: "build is not reproducible",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			mod := &fakeModule{binary: tt.binary}
+			cmd, err := buildCommand(context.Background(), opts, binaryPath)
+			require.NoError(t, err)
+			require.NoError(t, mod.Execute(cmd))
+
+			_, err = verifyReproducible(context.Background(), mod, opts, binaryPath)
+			if tt.err != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tt.err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			require.Len(t, mod.cmds, 2)
+			rebuild := mod.cmds[1]
+			require.Contains(t, rebuild.Args, "-trimpath")
+			require.NotContains(t, rebuild.Env, "GOCACHE=/gocache")
+			require.NotContains(t, rebuild.Env, "GOCACHEPROG=prog")
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"aead.dev/minisign"
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// Signing configures detached signatures of the outputs and the combined
+// checksum file. The private key is read from either a file or an environment
+// variable, and is never included in any output.
+type Signing struct {
+	// Format is the signature format: minisign (the default), which is
+	// written to "<file>.minisig", or openpgp, which is ASCII armored and
+	// written to "<file>.asc".
+	Format string `json:"format"`
+
+	// KeyFile is the path of the private key, and KeyEnv the name of an
+	// environment variable containing it. Exactly one must be set.
+	KeyFile string `json:"key_file"`
+	KeyEnv  string `json:"key_env"`
+
+	// PasswordEnv is the name of an environment variable containing the
+	// password of the private key, if it's encrypted. minisign keys are
+	// always encrypted.
+	PasswordEnv string `json:"password_env"`
+}
+
+// Signer creates detached signatures.
+type Signer interface {
+	// Sign returns a signature of the contents of r, which are those of the
+	// file with the given name.
+	Sign(name string, r io.Reader) ([]byte, error)
+
+	// Ext is the extension of signature files, e.g. ".minisig".
+	Ext() string
+}
+
+const (
+	SignMinisign = "minisign"
+	SignOpenPGP  = "openpgp"
+)
+
+// loadSigner reads the private key. To avoid leaking the key, errors never
+// include it, nor the errors from parsing it.
+func loadSigner(signing Signing) (Signer, error) {
+	var source string
+	var key []byte
+	switch {
+	case signing.KeyFile != "" && signing.KeyEnv != "":
+		return nil, fmt.Errorf("only one of key_file and key_env may be set for signing")
+	case signing.KeyFile != "":
+		source = signing.KeyFile
+		var err error
+		key, err = os.ReadFile(signing.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read signing key: %w", err)
+		}
+	case signing.KeyEnv != "":
+		source = "$" + signing.KeyEnv
+		key = []byte(os.Getenv(signing.KeyEnv))
+		if len(key) == 0 {
+			return nil, fmt.Errorf("signing key %s is not set", source)
+		}
+	default:
+		return nil, fmt.Errorf("one of key_file and key_env must be set for signing")
+	}
+
+	var password string
+	if signing.PasswordEnv != "" {
+		password = os.Getenv(signing.PasswordEnv)
+	}
+
+	switch signing.Format {
+	case "", SignMinisign:
+		privateKey, err := minisign.DecryptKey(password, bytes.TrimSpace(key))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt minisign key from %s: invalid key or password", source)
+		}
+		return minisignSigner{key: privateKey}, nil
+	case SignOpenPGP:
+		entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(key))
+		if err != nil {
+			entities, err = openpgp.ReadKeyRing(bytes.NewReader(key))
+		}
+		if err != nil || len(entities) == 0 || entities[0].PrivateKey == nil {
+			return nil, fmt.Errorf("failed to read OpenPGP private key from %s", source)
+		}
+		entity := entities[0]
+		if err := entity.DecryptPrivateKeys([]byte(password)); err != nil {
+			return nil, fmt.Errorf("failed to decrypt OpenPGP key from %s: invalid password", source)
+		}
+		return openPGPSigner{entity: entity}, nil
+	default:
+		return nil, fmt.Errorf("invalid signing format %q (must be one of %s or %s)", signing.Format, SignMinisign, SignOpenPGP)
+	}
+}
+
+// signFile writes a detached signature of the file alongside it, returning
+// the path of the signature.
+func signFile(signer Signer, file string) (string, error) {
+	srcFile, err := os.Open(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to open output file for signing: %w", err)
+	}
+	defer srcFile.Close()
+
+	name := filepath.Base(file)
+	signature, err := signer.Sign(name, srcFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign %s: %w", name, err)
+	}
+
+	outPath := file + signer.Ext()
+	if err := os.WriteFile(outPath, signature, 0644); err != nil {
+		return "", fmt.Errorf("failed to write signature: %w", err)
+	}
+	return outPath, nil
+}
+
+type minisignSigner struct {
+	key minisign.PrivateKey
+}
+
+func (s minisignSigner) Sign(name string, r io.Reader) ([]byte, error) {
+	reader := minisign.NewReader(r)
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return nil, err
+	}
+	// the same comments as the minisign tool
+	trustedComment := "timestamp:" + strconv.FormatInt(time.Now().Unix(), 10) + "\tfile:" + name + "\thashed"
+	untrustedComment := "signature from minisign secret key"
+	return reader.SignWithComments(s.key, trustedComment, untrustedComment), nil
+}
+
+func (minisignSigner) Ext() string {
+	return ".minisig"
+}
+
+type openPGPSigner struct {
+	entity *openpgp.Entity
+}
+
+func (s openPGPSigner) Sign(name string, r io.Reader) ([]byte, error) {
+	var signature bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&signature, s.entity, r, nil); err != nil {
+		return nil, err
+	}
+	return signature.Bytes(), nil
+}
+
+func (openPGPSigner) Ext() string {
+	return ".asc"
+}
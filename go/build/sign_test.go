@@ -0,0 +1,129 @@
+package build
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"aead.dev/minisign"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMinisignSigner(t *testing.T) {
+	// the key is encrypted with the password "password", using cheaper scrypt
+	// parameters than minisign.EncryptKey, which take seconds to decrypt
+	encryptedKey, err := ioutil.ReadFile("testdata/minisign.key")
+	require.NoError(t, err)
+	var publicKey minisign.PublicKey
+	publicKeyText, err := ioutil.ReadFile("testdata/minisign.pub")
+	require.NoError(t, err)
+	require.NoError(t, publicKey.UnmarshalText(bytes.TrimSpace(publicKeyText)))
+
+	t.Setenv("SIGNING_KEY", string(encryptedKey))
+	t.Setenv("SIGNING_PASSWORD", "password")
+	signer, err := loadSigner(Signing{KeyEnv: "SIGNING_KEY", PasswordEnv: "SIGNING_PASSWORD"})
+	require.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), "def.tar.gz")
+	require.NoError(t, ioutil.WriteFile(file, []byte("archive"), 0644))
+	signaturePath, err := signFile(signer, file)
+	require.NoError(t, err)
+	require.Equal(t, file+".minisig", signaturePath)
+
+	signature, err := ioutil.ReadFile(signaturePath)
+	require.NoError(t, err)
+	require.Contains(t, string(signature), "file:def.tar.gz")
+
+	reader := minisign.NewReader(strings.NewReader("archive"))
+	_, err = ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.True(t, reader.Verify(publicKey, signature))
+}
+
+func TestOpenPGPSigner(t *testing.T) {
+	entity, err := openpgp.NewEntity("def", "", "def@example.com", nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.EncryptPrivateKeys([]byte("password"), nil))
+
+	var privateKey bytes.Buffer
+	w, err := armor.Encode(&privateKey, openpgp.PrivateKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.SerializePrivateWithoutSigning(w, nil))
+	require.NoError(t, w.Close())
+
+	keyFile := filepath.Join(t.TempDir(), "key.asc")
+	require.NoError(t, ioutil.WriteFile(keyFile, privateKey.Bytes(), 0600))
+
+	t.Setenv("SIGNING_PASSWORD", "password")
+	signer, err := loadSigner(Signing{Format: "openpgp", KeyFile: keyFile, PasswordEnv: "SIGNING_PASSWORD"})
+	require.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), "SHA256SUMS")
+	require.NoError(t, ioutil.WriteFile(file, []byte("checksums"), 0644))
+	signaturePath, err := signFile(signer, file)
+	require.NoError(t, err)
+	require.Equal(t, file+".asc", signaturePath)
+
+	signature, err := os.Open(signaturePath)
+	require.NoError(t, err)
+	defer signature.Close()
+	_, err = openpgp.CheckArmoredDetachedSignature(openpgp.EntityList{entity}, strings.NewReader("checksums"), signature, nil)
+	require.NoError(t, err)
+}
+
+func TestLoadSignerErrors(t *testing.T) {
+	t.Setenv("WRONG_PASSWORD", "wrong")
+	t.Setenv("NOT_A_KEY", "secret-key-material")
+
+	for _, tt := range []struct {
+		desc    string
+		signing Signing
+		err     string
+	}{
+		{
+			desc:    "no key",
+			signing: Signing{},
+			err:     "one of key_file and key_env must be set for signing",
+		},
+		{
+			desc:    "both key file and env var",
+			signing: Signing{KeyFile: "testdata/minisign.key", KeyEnv: "NOT_A_KEY"},
+			err:     "only one of key_file and key_env may be set for signing",
+		},
+		{
+			desc:    "unset env var",
+			signing: Signing{KeyEnv: "UNSET_SIGNING_KEY"},
+			err:     "signing key $UNSET_SIGNING_KEY is not set",
+		},
+		{
+			desc:    "wrong password",
+			signing: Signing{KeyFile: "testdata/minisign.key", PasswordEnv: "WRONG_PASSWORD"},
+			err:     "failed to decrypt minisign key from testdata/minisign.key: invalid key or password",
+		},
+		{
+			desc:    "invalid minisign key",
+			signing: Signing{KeyEnv: "NOT_A_KEY"},
+			err:     "failed to decrypt minisign key from $NOT_A_KEY: invalid key or password",
+		},
+		{
+			desc:    "invalid OpenPGP key",
+			signing: Signing{Format: "openpgp", KeyEnv: "NOT_A_KEY"},
+			err:     "failed to read OpenPGP private key from $NOT_A_KEY",
+		},
+		{
+			desc:    "invalid format",
+			signing: Signing{Format: "gpg", KeyFile: "testdata/minisign.key"},
+			err:     `invalid signing format "gpg" (must be one of minisign or openpgp)`,
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			_, err := loadSigner(tt.signing)
+			require.EqualError(t, err, tt.err)
+		})
+	}
+}
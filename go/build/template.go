@@ -0,0 +1,187 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+	"unicode"
+)
+
+const DefaultOutputTemplate = "{{.Dir}}-{{.OS}}-{{.Arch}}{{with .ArchVariant}}-{{.}}{{end}}{{with .Variant}}-{{.}}{{end}}"
+
+// OutputTemplateParams are available to the output template. The rendered
+// template is a path relative to the output directory, so it may place
+// binaries in subdirectories, e.g.
+//
+//	{{.Version}}/{{.OS}}_{{.Arch}}/{{.Dir}}{{.Ext}}
+type OutputTemplateParams struct {
+	// Dir is the name of the package's directory, and ImportPath its full
+	// import path.
+	Dir        string
+	ImportPath string
+
+	OS          string
+	Arch        string
+	ArchVariant string
+
+	// Variant is the name of the build variant, if any.
+	Variant string
+
+	// Ext is the extension of executables on the platform (".exe" on
+	// Windows). If the template doesn't use it, it is appended to the
+	// rendered template.
+	Ext string
+
+	// ArchiveFormat is the format of the archive the binary will be placed
+	// in (e.g. "tar.gz"), if archiving is enabled.
+	ArchiveFormat string
+
+	Version string
+	Commit  string
+	Date    time.Time
+	Dirty   bool
+}
+
+var templateFuncs = template.FuncMap{
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"title":      title,
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"trimprefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"trimsuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+}
+
+// title upper-cases the first letter of each word in s.
+func title(s string) string {
+	prev := ' '
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(prev) || prev == '-' || prev == '_' {
+			r = unicode.ToUpper(r)
+		}
+		prev = r
+		return r
+	}, s)
+}
+
+func parseOutputTemplate(text string) (*template.Template, error) {
+	return template.New("output").Funcs(templateFuncs).Parse(text)
+}
+
+func renderOutputTemplate(tmpl *template.Template, params OutputTemplateParams) (string, error) {
+	var suffix string
+	if !usesField(tmpl, "Ext") {
+		suffix = params.Ext
+	}
+	return renderPath(tmpl, params, suffix, "output %q must be a relative path within the output directory")
+}
+
+// renderArchiveTemplate renders the name of an archive, which has the
+// archive format as its extension unless the template uses it.
+func renderArchiveTemplate(tmpl *template.Template, params OutputTemplateParams) (string, error) {
+	var suffix string
+	if !usesField(tmpl, "ArchiveFormat") {
+		suffix = "." + params.ArchiveFormat
+	}
+	return renderPath(tmpl, params, suffix, "archive %q must be a relative path within the output directory")
+}
+
+// renderWrapDirTemplate renders the directory to place archive contents in,
+// as a slash-separated path.
+func renderWrapDirTemplate(tmpl *template.Template, params OutputTemplateParams) (string, error) {
+	dir, err := renderPath(tmpl, params, "", "archive wrap dir %q must be a relative path")
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(dir), nil
+}
+
+func renderPath(tmpl *template.Template, params OutputTemplateParams, suffix, invalidFormat string) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, params); err != nil {
+		return "", err
+	}
+	name := filepath.Clean(filepath.FromSlash(buf.String() + suffix))
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf(invalidFormat, name)
+	}
+	return name, nil
+}
+
+// usesField returns whether the template (or any template it defines)
+// references the given field, as either .Field or $.Field.
+func usesField(tmpl *template.Template, field string) bool {
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil && nodeUsesField(t.Root, field) {
+			return true
+		}
+	}
+	return false
+}
+
+func nodeUsesField(node parse.Node, field string) bool {
+	switch node := node.(type) {
+	case *parse.FieldNode:
+		return node.Ident[0] == field
+	case *parse.VariableNode:
+		return len(node.Ident) > 1 && node.Ident[0] == "$" && node.Ident[1] == field
+	case *parse.ChainNode:
+		return nodeUsesField(node.Node, field)
+	case *parse.ListNode:
+		if node == nil {
+			return false
+		}
+		for _, n := range node.Nodes {
+			if nodeUsesField(n, field) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return nodeUsesField(node.Pipe, field)
+	case *parse.TemplateNode:
+		return node.Pipe != nil && nodeUsesField(node.Pipe, field)
+	case *parse.PipeNode:
+		if node == nil {
+			return false
+		}
+		for _, cmd := range node.Cmds {
+			if nodeUsesField(cmd, field) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range node.Args {
+			if nodeUsesField(arg, field) {
+				return true
+			}
+		}
+	case *parse.IfNode:
+		return nodeUsesBranchField(&node.BranchNode, field)
+	case *parse.RangeNode:
+		return nodeUsesBranchField(&node.BranchNode, field)
+	case *parse.WithNode:
+		return nodeUsesBranchField(&node.BranchNode, field)
+	}
+	return false
+}
+
+func nodeUsesBranchField(node *parse.BranchNode, field string) bool {
+	return nodeUsesField(node.Pipe, field) || nodeUsesField(node.List, field) || nodeUsesField(node.ElseList, field)
+}
+
+func executableExt(platform Platform) string {
+	if platform.OS == "windows" {
+		return ".exe"
+	}
+	return ""
+}
+
+func archiveFormat(opts Options) string {
+	if !opts.Archive {
+		return ""
+	}
+	return opts.ArchiveFormat
+}
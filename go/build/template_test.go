@@ -0,0 +1,126 @@
+package build
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderOutputTemplate(t *testing.T) {
+	params := OutputTemplateParams{
+		Dir:           "def",
+		ImportPath:    "github.com/abc/def",
+		OS:            "windows",
+		Arch:          "amd64",
+		Variant:       "pure",
+		Ext:           ".exe",
+		ArchiveFormat: "zip",
+		Version:       "v1.2.3",
+		Commit:        "abc123",
+		Date:          time.Date(2021, 5, 7, 0, 0, 0, 0, time.UTC),
+	}
+
+	for _, tt := range []struct {
+		template string
+		output   string
+		err      string
+	}{
+		{
+			template: DefaultOutputTemplate,
+			output:   "def-windows-amd64-pure.exe",
+		},
+		{
+			template: "{{.Version}}/{{.OS}}_{{.Arch}}/{{.Dir}}{{.Ext}}",
+			output:   "v1.2.3/windows_amd64/def.exe",
+		},
+		{
+			template: "{{.Dir}}{{if .Ext}}-win{{end}}",
+			output:   "def-win",
+		},
+		{
+			template: "{{.ImportPath | trimprefix \"github.com/\" | replace \"/\" \"-\"}}-{{.Commit}}-{{.Date.Format \"20060102\"}}",
+			output:   "abc-def-abc123-20210507.exe",
+		},
+		{
+			template: "{{.OS | title}}-{{.Variant | upper}}-{{.ArchiveFormat}}{{.Ext}}",
+			output:   "Windows-PURE-zip.exe",
+		},
+		{
+			template: "../{{.Dir}}",
+			err:      `output "../def.exe" must be a relative path within the output directory`,
+		},
+	} {
+		tmpl, err := parseOutputTemplate(tt.template)
+		require.NoError(t, err)
+
+		output, err := renderOutputTemplate(tmpl, params)
+		if tt.err != "" {
+			require.EqualError(t, err, tt.err)
+		} else {
+			require.NoError(t, err)
+			require.Equal(t, tt.output, output)
+		}
+	}
+}
+
+func TestRenderArchiveTemplate(t *testing.T) {
+	params := OutputTemplateParams{
+		Dir:           "def",
+		OS:            "linux",
+		Arch:          "arm64",
+		ArchiveFormat: "tar.gz",
+		Version:       "v1.2.3",
+	}
+
+	for _, tt := range []struct {
+		template string
+		output   string
+		err      string
+	}{
+		{
+			template: "{{.Dir}}_{{.Version}}_{{.OS}}_{{.Arch}}",
+			output:   "def_v1.2.3_linux_arm64.tar.gz",
+		},
+		{
+			template: "{{.Dir}}.{{if eq .ArchiveFormat \"tar.gz\"}}tgz{{end}}",
+			output:   "def.tgz",
+		},
+		{
+			template: "/{{.Dir}}",
+			err:      `archive "/def.tar.gz" must be a relative path within the output directory`,
+		},
+	} {
+		tmpl, err := parseOutputTemplate(tt.template)
+		require.NoError(t, err)
+
+		output, err := renderArchiveTemplate(tmpl, params)
+		if tt.err != "" {
+			require.EqualError(t, err, tt.err)
+		} else {
+			require.NoError(t, err)
+			require.Equal(t, tt.output, output)
+		}
+	}
+}
+
+func TestUsesField(t *testing.T) {
+	for _, tt := range []struct {
+		template string
+		uses     bool
+	}{
+		{template: "{{.Dir}}{{.Ext}}", uses: true},
+		{template: "{{.Dir}}", uses: false},
+		// fields that merely start with the name don't count
+		{template: "{{.Dir}}{{.Extension}}", uses: false},
+		{template: "{{.Dir}}.Ext", uses: false},
+		{template: `{{if eq .OS "windows"}}{{.Ext}}{{end}}`, uses: true},
+		{template: `{{with .Variant}}{{.}}{{else}}{{$.Ext}}{{end}}`, uses: true},
+		{template: `{{.Dir}}{{printf "%s" .Ext}}`, uses: true},
+		{template: `{{define "ext"}}{{.Ext}}{{end}}{{.Dir}}{{template "ext" .}}`, uses: true},
+	} {
+		tmpl, err := parseOutputTemplate(tt.template)
+		require.NoError(t, err)
+		require.Equal(t, tt.uses, usesField(tmpl, "Ext"), tt.template)
+	}
+}
@@ -10,13 +10,19 @@ type Status struct {
 	ID
 	Status string
 	Data   string
+	Class  FailureClass
 }
 
 type State struct {
 	Status    string
 	Error     string
+	Class     FailureClass
 	Line      int
 	StartTime time.Time
+
+	// ArchiveStartTime is when the build finished compiling and started
+	// archiving, if it's archived.
+	ArchiveStartTime time.Time
 }
 
 type UI struct {
@@ -42,11 +48,29 @@ func (ui *UI) Update(status Status) {
 	case "start":
 		state.StartTime = time.Now()
 		statusText = "\x1b[36mbuilding\x1b[0m"
+	case "archiving":
+		state.ArchiveStartTime = time.Now()
+		statusText = fmt.Sprintf("\x1b[36marchiving\x1b[0m (built in %s)", state.ArchiveStartTime.Sub(state.StartTime))
 	case "success":
-		statusText = fmt.Sprintf("\x1b[32mfinished\x1b[0m (%s)", time.Since(state.StartTime))
+		if state.ArchiveStartTime.IsZero() {
+			statusText = fmt.Sprintf("\x1b[32mfinished\x1b[0m (%s)", time.Since(state.StartTime))
+		} else {
+			statusText = fmt.Sprintf("\x1b[32mfinished\x1b[0m (build %s, archive %s)", state.ArchiveStartTime.Sub(state.StartTime), time.Since(state.ArchiveStartTime))
+		}
+	case "verifying":
+		statusText = "\x1b[36mverifying\x1b[0m"
+	case "retrying":
+		statusText = fmt.Sprintf("\x1b[33mretrying\x1b[0m (%s error, %s)", status.Class, status.Data)
 	case "error":
 		state.Error = status.Data
-		statusText = fmt.Sprintf("\x1b[31merrored\x1b[0m  (%s)", time.Since(state.StartTime))
+		state.Class = status.Class
+		if status.Class != "" {
+			statusText = fmt.Sprintf("\x1b[31merrored\x1b[0m  (%s error, %s)", status.Class, time.Since(state.StartTime))
+		} else {
+			statusText = fmt.Sprintf("\x1b[31merrored\x1b[0m  (%s)", time.Since(state.StartTime))
+		}
+	case "cancelled":
+		statusText = fmt.Sprintf("\x1b[33mcancelled\x1b[0m (%s)", time.Since(state.StartTime))
 	case "skipped":
 		reason := status.Data
 		statusText = fmt.Sprintf("\x1b[33mskipped\x1b[0m  (%s)", reason)
@@ -77,6 +101,9 @@ func (ui UI) setStatusText(buildID ID, line int, text string) {
 }
 
 func (ui UI) buildLinePrefix(buildID ID) string {
+	if buildID.Variant != "" {
+		return fmt.Sprintf("--> %15s: %s [%s] ... ", buildID.Platform, buildID.Package, buildID.Variant)
+	}
 	return fmt.Sprintf("--> %15s: %s ... ", buildID.Platform, buildID.Package)
 }
 
@@ -84,6 +111,7 @@ func (ui UI) PrintResult() {
 	type BuildError struct {
 		ID
 		Error string
+		Class FailureClass
 	}
 	var buildErrors []BuildError
 	for buildID, state := range ui.BuildStates {
@@ -91,6 +119,7 @@ func (ui UI) PrintResult() {
 			buildErrors = append(buildErrors, BuildError{
 				ID:    buildID,
 				Error: state.Error,
+				Class: state.Class,
 			})
 		}
 	}
@@ -107,10 +136,18 @@ func (ui UI) PrintResult() {
 
 	// Sort by line so that errors appear in same order as builds
 	sort.Slice(buildErrors, func(i, j int) bool {
-		return ui.BuildStates[buildErrors[i].ID].Line < ui.BuildStates[buildErrors[i].ID].Line
+		return ui.BuildStates[buildErrors[i].ID].Line < ui.BuildStates[buildErrors[j].ID].Line
 	})
 
 	for _, err := range buildErrors {
-		fmt.Printf("--> %15s: %s: %s\n\n", err.Platform, err.Package, err.Error)
+		pkg := err.Package
+		if err.Variant != "" {
+			pkg += " [" + err.Variant + "]"
+		}
+		if err.Class != "" {
+			fmt.Printf("--> %15s: %s: (%s) %s\n\n", err.Platform, pkg, err.Class, err.Error)
+		} else {
+			fmt.Printf("--> %15s: %s: %s\n\n", err.Platform, pkg, err.Error)
+		}
 	}
 }
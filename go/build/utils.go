@@ -3,6 +3,7 @@ package build
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 )
 
 type OneOrMany []string
@@ -24,3 +25,29 @@ func (o *OneOrMany) UnmarshalJSON(data []byte) error {
 	}
 	return fmt.Errorf("must be either a string or a []string")
 }
+
+// Duration is a time.Duration that may be given either as a string (e.g.
+// "1m30s") or a number of seconds.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	{
+		var seconds float64
+		if err := json.Unmarshal(data, &seconds); err == nil {
+			*d = Duration(seconds * float64(time.Second))
+			return nil
+		}
+	}
+	{
+		var str string
+		if err := json.Unmarshal(data, &str); err == nil {
+			duration, err := time.ParseDuration(str)
+			if err != nil {
+				return err
+			}
+			*d = Duration(duration)
+			return nil
+		}
+	}
+	return fmt.Errorf("must be either a number or a string")
+}
@@ -0,0 +1,126 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aoldershaw/prototype-experiments/go/module"
+	"golang.org/x/mod/semver"
+)
+
+// VersionInfo describes the source being built, as derived from git.
+type VersionInfo struct {
+	// Version is the nearest semver tag if HEAD is tagged, and otherwise the
+	// output of `git describe` (e.g. "v1.2.3-4-gabc1234"). If there are no
+	// semver tags, it is "v0.0.0-" followed by the short commit hash.
+	Version string
+	Commit  string
+	// Date is the commit time of HEAD, so that it is the same for every build
+	// of a commit.
+	Date time.Time
+	// Dirty is true if the work tree has uncommitted changes.
+	Dirty bool
+}
+
+const semverTagPattern = "v[0-9]*"
+
+func versionInfo(mod Module) (VersionInfo, error) {
+	git := func(args ...string) (string, error) {
+		var stdout bytes.Buffer
+		cmd := exec.Command("git", args...)
+		cmd.Stdout = &stdout
+		if err := mod.Execute(cmd); err != nil {
+			return "", fmt.Errorf("git %s: %w", args[0], err)
+		}
+		return strings.TrimSpace(stdout.String()), nil
+	}
+
+	var info VersionInfo
+	var err error
+	info.Commit, err = git("rev-parse", "HEAD")
+	if err != nil {
+		return VersionInfo{}, err
+	}
+
+	commitTime, err := git("show", "-s", "--format=%cI", "HEAD")
+	if err != nil {
+		return VersionInfo{}, err
+	}
+	info.Date, err = time.Parse(time.RFC3339, commitTime)
+	if err != nil {
+		return VersionInfo{}, fmt.Errorf("invalid commit time: %w", err)
+	}
+	info.Date = info.Date.UTC()
+
+	// nearest tag fails if there are no tags
+	tag, err := git("describe", "--tags", "--abbrev=0", "--match", semverTagPattern)
+	if err == nil && semver.IsValid(tag) {
+		info.Version, err = git("describe", "--tags", "--match", semverTagPattern)
+		if err != nil {
+			return VersionInfo{}, err
+		}
+	} else {
+		info.Version = "v0.0.0-" + shortCommit(info.Commit)
+	}
+
+	status, err := git("status", "--porcelain")
+	if err != nil {
+		return VersionInfo{}, err
+	}
+	info.Dirty = status != ""
+
+	return info, nil
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
+
+// parseVersionVariables parses the templates of the version_variables param.
+func parseVersionVariables(vars map[string]string) (map[string]*template.Template, error) {
+	templates := map[string]*template.Template{}
+	for name, text := range vars {
+		if !strings.Contains(name, ".") {
+			return nil, fmt.Errorf("version variable %q must be of the form importpath.name", name)
+		}
+		tmpl, err := template.New(name).Funcs(templateFuncs).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template for version variable %s: %w", name, err)
+		}
+		templates[name] = tmpl
+	}
+	return templates, nil
+}
+
+// versionLdflags renders the version variables as -X linker flags, sorted by
+// variable name.
+func versionLdflags(templates map[string]*template.Template, params OutputTemplateParams) (string, error) {
+	var names []string
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var flags []string
+	for _, name := range names {
+		var value bytes.Buffer
+		if err := templates[name].Execute(&value, params); err != nil {
+			return "", err
+		}
+		// the go command splits -ldflags with its own quoting rules
+		flag, err := module.Quote(name + "=" + value.String())
+		if err != nil {
+			return "", fmt.Errorf("invalid version variable %s: %w", name, err)
+		}
+		flags = append(flags, "-X "+flag)
+	}
+	return strings.Join(flags, " "), nil
+}
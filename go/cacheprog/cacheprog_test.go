@@ -0,0 +1,182 @@
+package cacheprog_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aoldershaw/prototype-experiments/go/cacheprog"
+	"github.com/stretchr/testify/require"
+)
+
+// session runs a Client over the given requests and returns its responses.
+// Put requests are followed by their body.
+func session(t *testing.T, client cacheprog.Client, reqs []cacheprog.Request, bodies map[int64]string) []cacheprog.Response {
+	var in bytes.Buffer
+	for _, req := range reqs {
+		payload, err := json.Marshal(req)
+		require.NoError(t, err)
+		in.Write(payload)
+		in.WriteByte('\n')
+		if body, ok := bodies[req.ID]; ok {
+			fmt.Fprintf(&in, "%q\n", base64.StdEncoding.EncodeToString([]byte(body)))
+		}
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, client.Serve(&in, &out))
+
+	var resps []cacheprog.Response
+	decoder := json.NewDecoder(&out)
+	for decoder.More() {
+		var resp cacheprog.Response
+		require.NoError(t, decoder.Decode(&resp))
+		resps = append(resps, resp)
+	}
+	return resps
+}
+
+func byID(resps []cacheprog.Response) map[int64]cacheprog.Response {
+	m := map[int64]cacheprog.Response{}
+	for _, resp := range resps {
+		m[resp.ID] = resp
+	}
+	return m
+}
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(cacheprog.Server{Dir: t.TempDir()})
+	defer server.Close()
+
+	actionID := []byte{0xab, 0xcd}
+	body := "compiled output"
+	// output IDs are the hash of the output
+	sum := sha256.Sum256([]byte(body))
+	outputID := sum[:]
+
+	localDir := t.TempDir()
+
+	t.Log("miss before the output is stored")
+	resps := byID(session(t, cacheprog.Client{URL: server.URL, Dir: localDir}, []cacheprog.Request{
+		{ID: 1, Command: cacheprog.CmdGet, ActionID: actionID},
+		{ID: 2, Command: cacheprog.CmdClose},
+	}, nil))
+
+	require.Equal(t, []cacheprog.Cmd{cacheprog.CmdGet, cacheprog.CmdPut, cacheprog.CmdClose}, resps[0].KnownCommands)
+	require.True(t, resps[1].Miss)
+	require.Contains(t, resps, int64(2))
+
+	t.Log("put through one client")
+	resps = byID(session(t, cacheprog.Client{URL: server.URL, Dir: localDir}, []cacheprog.Request{
+		{ID: 1, Command: cacheprog.CmdPut, ActionID: actionID, OutputID: outputID, BodySize: int64(len(body))},
+		{ID: 2, Command: cacheprog.CmdClose},
+	}, map[int64]string{1: body}))
+
+	require.Empty(t, resps[1].Err)
+	require.NotEmpty(t, resps[1].DiskPath)
+
+	t.Log("get through another client with an empty local cache")
+	resps = byID(session(t, cacheprog.Client{URL: server.URL, Dir: t.TempDir()}, []cacheprog.Request{
+		{ID: 1, Command: cacheprog.CmdGet, ActionID: actionID},
+		{ID: 2, Command: cacheprog.CmdClose},
+	}, nil))
+
+	resp := resps[1]
+	require.False(t, resp.Miss)
+	require.Equal(t, outputID, resp.OutputID)
+	require.Equal(t, int64(len(body)), resp.Size)
+	require.NotNil(t, resp.Time)
+
+	contents, err := ioutil.ReadFile(resp.DiskPath)
+	require.NoError(t, err)
+	require.Equal(t, body, string(contents))
+}
+
+func TestClientCorruptRemote(t *testing.T) {
+	server := httptest.NewServer(cacheprog.Server{Dir: t.TempDir()})
+	defer server.Close()
+
+	// the remote output doesn't hash to its output ID
+	req := httptest.NewRequest("PUT", server.URL+"/cache/abcd", strings.NewReader("tampered"))
+	req.RequestURI = ""
+	req.Header.Set("Go-Output-Id", "1234")
+	resp, err := server.Client().Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, 204, resp.StatusCode)
+
+	localDir := t.TempDir()
+	resps := byID(session(t, cacheprog.Client{URL: server.URL, Dir: localDir}, []cacheprog.Request{
+		{ID: 1, Command: cacheprog.CmdGet, ActionID: []byte{0xab, 0xcd}},
+		{ID: 2, Command: cacheprog.CmdClose},
+	}, nil))
+	require.True(t, resps[1].Miss)
+
+	_, err = os.Stat(filepath.Join(localDir, "o", "1234"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestClientRemoteUnavailable(t *testing.T) {
+	server := httptest.NewServer(cacheprog.Server{Dir: t.TempDir()})
+	server.Close()
+
+	client := cacheprog.Client{URL: server.URL, Dir: t.TempDir()}
+	resps := byID(session(t, client, []cacheprog.Request{
+		{ID: 1, Command: cacheprog.CmdPut, ActionID: []byte{0x01}, OutputID: []byte{0x02}, BodySize: 3},
+		{ID: 2, Command: cacheprog.CmdGet, ActionID: []byte{0x03}},
+		{ID: 3, Command: cacheprog.CmdClose},
+	}, map[int64]string{1: "abc"}))
+
+	require.Empty(t, resps[1].Err)
+	require.True(t, resps[2].Miss)
+}
+
+func TestServer(t *testing.T) {
+	server := httptest.NewServer(cacheprog.Server{Dir: t.TempDir()})
+	defer server.Close()
+
+	for _, tt := range []struct {
+		desc   string
+		method string
+		path   string
+		header map[string]string
+		body   string
+		status int
+	}{
+		{desc: "miss", method: "GET", path: "/cache/abcd", status: 404},
+		{desc: "invalid id", method: "GET", path: "/cache/xyz", status: 404},
+		{desc: "missing output id", method: "PUT", path: "/cache/abcd", body: "x", status: 400},
+		{desc: "put", method: "PUT", path: "/cache/abcd", header: map[string]string{"Go-Output-Id": "1234"}, body: "x", status: 204},
+		{desc: "hit", method: "GET", path: "/cache/abcd", status: 200},
+		{desc: "unsupported method", method: "DELETE", path: "/cache/abcd", status: 405},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, server.URL+tt.path, strings.NewReader(tt.body))
+			req.RequestURI = ""
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			resp, err := server.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, tt.status, resp.StatusCode)
+		})
+	}
+}
+
+func TestCommand(t *testing.T) {
+	command, err := cacheprog.Command("/opt/my tools/proto", "http://cache:8080", "/tmp/it's/prog")
+	require.NoError(t, err)
+	require.Equal(t, `'/opt/my tools/proto' cacheprog -url http://cache:8080 -dir "/tmp/it's/prog"`, command)
+
+	_, err = cacheprog.Command("/opt/proto", "http://cache:8080", `/tmp/it's "quoted"`)
+	require.Error(t, err)
+}
@@ -0,0 +1,252 @@
+package cacheprog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aoldershaw/prototype-experiments/go/module"
+)
+
+// Client is a GOCACHEPROG helper. It stores outputs in a local directory (the
+// go command requires outputs to be on disk), and falls back to a remote
+// cache Server on a local miss. Puts are written to both.
+//
+// The remote cache is best-effort: errors talking to it are logged and
+// treated as misses, so that an unavailable server only slows builds down.
+type Client struct {
+	URL  string
+	Dir  string
+	HTTP *http.Client
+}
+
+// Serve speaks the GOCACHEPROG protocol, reading requests from r and writing
+// responses to w until a "close" request is received or r is closed.
+func (c Client) Serve(r io.Reader, w io.Writer) error {
+	if c.HTTP == nil {
+		c.HTTP = http.DefaultClient
+	}
+
+	var writeMu sync.Mutex
+	encoder := json.NewEncoder(w)
+	send := func(resp Response) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return encoder.Encode(resp)
+	}
+
+	if err := send(Response{KnownCommands: []Cmd{CmdGet, CmdPut, CmdClose}}); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var req Request
+		err := decoder.Decode(&req)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("decode request: %w", err)
+		}
+
+		var body []byte
+		if req.Command == CmdPut && req.BodySize > 0 {
+			if err := decoder.Decode(&body); err != nil {
+				return fmt.Errorf("decode request body: %w", err)
+			}
+			if int64(len(body)) != req.BodySize {
+				return fmt.Errorf("request body size mismatch: got %d, want %d", len(body), req.BodySize)
+			}
+		}
+
+		if req.Command == CmdClose {
+			wg.Wait()
+			return send(Response{ID: req.ID})
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var resp Response
+			switch req.Command {
+			case CmdGet:
+				resp = c.get(req)
+			case CmdPut:
+				resp = c.put(req, body)
+			default:
+				resp = Response{ID: req.ID, Err: fmt.Sprintf("unknown command %q", req.Command)}
+			}
+			if err := send(resp); err != nil {
+				log.Printf("cacheprog: failed to send response: %s", err)
+				return
+			}
+			if req.Command == CmdPut && resp.Err == "" {
+				c.upload(req)
+			}
+		}()
+	}
+}
+
+func (c Client) get(req Request) Response {
+	cache := diskCache{dir: c.Dir}
+	actionID := hex.EncodeToString(req.ActionID)
+
+	entry, ok := cache.get(actionID)
+	if !ok {
+		entry, ok = c.download(actionID)
+	}
+	if !ok {
+		return Response{ID: req.ID, Miss: true}
+	}
+
+	outputID, err := hex.DecodeString(entry.OutputID)
+	if err != nil {
+		return Response{ID: req.ID, Miss: true}
+	}
+	return Response{
+		ID:       req.ID,
+		OutputID: outputID,
+		Size:     entry.Size,
+		Time:     &entry.Time,
+		DiskPath: cache.outputPath(entry.OutputID),
+	}
+}
+
+func (c Client) put(req Request, body []byte) Response {
+	cache := diskCache{dir: c.Dir}
+	outputID := hex.EncodeToString(req.OutputID)
+
+	_, err := cache.put(hex.EncodeToString(req.ActionID), outputID, bytes.NewReader(body), time.Now(), false)
+	if err != nil {
+		return Response{ID: req.ID, Err: err.Error()}
+	}
+	return Response{ID: req.ID, DiskPath: cache.outputPath(outputID)}
+}
+
+// download fetches an entry from the remote cache into the local cache.
+func (c Client) download(actionID string) (actionEntry, bool) {
+	if c.URL == "" {
+		return actionEntry{}, false
+	}
+
+	resp, err := c.HTTP.Get(c.cacheURL(actionID))
+	if err != nil {
+		log.Printf("cacheprog: get %s: %s", actionID, err)
+		return actionEntry{}, false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode != http.StatusNotFound {
+			log.Printf("cacheprog: get %s: unexpected status %s", actionID, resp.Status)
+		}
+		return actionEntry{}, false
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, resp.Header.Get(timeHeader))
+	if err != nil {
+		t = time.Now()
+	}
+	// the output ID is the hash of the output, so a corrupt or tampered
+	// download is treated as a miss rather than being used by the build
+	cache := diskCache{dir: c.Dir}
+	entry, err := cache.put(actionID, resp.Header.Get(outputIDHeader), resp.Body, t, true)
+	if err != nil {
+		log.Printf("cacheprog: get %s: %s", actionID, err)
+		return actionEntry{}, false
+	}
+	return entry, true
+}
+
+// upload copies an entry from the local cache to the remote cache.
+func (c Client) upload(req Request) {
+	if c.URL == "" {
+		return
+	}
+
+	actionID := hex.EncodeToString(req.ActionID)
+	outputID := hex.EncodeToString(req.OutputID)
+	file, err := os.Open(diskCache{dir: c.Dir}.outputPath(outputID))
+	if err != nil {
+		log.Printf("cacheprog: put %s: %s", actionID, err)
+		return
+	}
+	defer file.Close()
+
+	httpReq, err := http.NewRequest(http.MethodPut, c.cacheURL(actionID), file)
+	if err != nil {
+		log.Printf("cacheprog: put %s: %s", actionID, err)
+		return
+	}
+	httpReq.ContentLength = req.BodySize
+	httpReq.Header.Set(outputIDHeader, outputID)
+
+	resp, err := c.HTTP.Do(httpReq)
+	if err != nil {
+		log.Printf("cacheprog: put %s: %s", actionID, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		log.Printf("cacheprog: put %s: unexpected status %s", actionID, resp.Status)
+	}
+}
+
+func (c Client) cacheURL(actionID string) string {
+	return strings.TrimSuffix(c.URL, "/") + "/cache/" + actionID
+}
+
+// Main runs the cache helper over stdin and stdout, as invoked by the go
+// command through GOCACHEPROG. args are the command-line arguments (excluding
+// the program and subcommand names).
+func Main(args []string) error {
+	flags := flag.NewFlagSet("cacheprog", flag.ContinueOnError)
+	url := flags.String("url", "", "URL of the remote cache server")
+	dir := flags.String("dir", "", "local directory to store cache entries in")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return errors.New("-dir must be specified")
+	}
+	// the go command reads outputs from the paths we return, which may be
+	// resolved relative to a different directory
+	absDir, err := filepath.Abs(*dir)
+	if err != nil {
+		return err
+	}
+
+	return Client{URL: *url, Dir: absDir}.Serve(os.Stdin, os.Stdout)
+}
+
+// Command returns the value of GOCACHEPROG for running the cache helper
+// through the given executable (the prototype). The go command splits the
+// value into arguments, so each is quoted as needed.
+func Command(executable, url, dir string) (string, error) {
+	args := []string{executable, "cacheprog", "-url", url, "-dir", dir}
+	for i, arg := range args {
+		quoted, err := module.Quote(arg)
+		if err != nil {
+			return "", fmt.Errorf("invalid GOCACHEPROG argument: %w", err)
+		}
+		args[i] = quoted
+	}
+	return strings.Join(args, " "), nil
+}
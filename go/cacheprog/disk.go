@@ -0,0 +1,126 @@
+package cacheprog
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// diskCache stores cache entries in a directory. Actions are stored as JSON
+// metadata under a/, and outputs are stored under o/, both keyed by their
+// hex-encoded IDs. Writes are atomic, so the same directory may be shared by
+// concurrent processes.
+type diskCache struct {
+	dir string
+}
+
+type actionEntry struct {
+	OutputID string    `json:"output_id"`
+	Size     int64     `json:"size"`
+	Time     time.Time `json:"time"`
+}
+
+func (c diskCache) actionPath(actionID string) string {
+	return filepath.Join(c.dir, "a", actionID)
+}
+
+func (c diskCache) outputPath(outputID string) string {
+	return filepath.Join(c.dir, "o", outputID)
+}
+
+// get returns the action entry for actionID, or false if it is not in the
+// cache (or its output has gone missing).
+func (c diskCache) get(actionID string) (actionEntry, bool) {
+	payload, err := ioutil.ReadFile(c.actionPath(actionID))
+	if err != nil {
+		return actionEntry{}, false
+	}
+	var entry actionEntry
+	if err := json.Unmarshal(payload, &entry); err != nil {
+		return actionEntry{}, false
+	}
+	info, err := os.Stat(c.outputPath(entry.OutputID))
+	if err != nil || info.Size() != entry.Size {
+		return actionEntry{}, false
+	}
+	return entry, true
+}
+
+// put stores the output read from body, and records it as the result of
+// actionID. If verify is true, the output must hash to outputID (as the go
+// command's outputs do), or nothing is stored.
+func (c diskCache) put(actionID, outputID string, body io.Reader, t time.Time, verify bool) (actionEntry, error) {
+	if !validID(actionID) || !validID(outputID) {
+		return actionEntry{}, fmt.Errorf("invalid cache key")
+	}
+
+	var check func() error
+	if verify {
+		hash := sha256.New()
+		body = io.TeeReader(body, hash)
+		check = func() error {
+			if sum := hex.EncodeToString(hash.Sum(nil)); sum != outputID {
+				return fmt.Errorf("output hashes to %s rather than its output ID", sum)
+			}
+			return nil
+		}
+	}
+
+	size, err := c.writeAtomic(c.outputPath(outputID), body, check)
+	if err != nil {
+		return actionEntry{}, fmt.Errorf("write output: %w", err)
+	}
+
+	entry := actionEntry{OutputID: outputID, Size: size, Time: t}
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return actionEntry{}, err
+	}
+	if _, err := c.writeAtomic(c.actionPath(actionID), bytes.NewReader(payload), nil); err != nil {
+		return actionEntry{}, fmt.Errorf("write action: %w", err)
+	}
+	return entry, nil
+}
+
+// writeAtomic writes the contents of r to path, unless check (if given)
+// returns an error once they've been read.
+func (c diskCache) writeAtomic(path string, r io.Reader, check func() error) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if check != nil {
+		if err := check(); err != nil {
+			return 0, err
+		}
+	}
+	return size, os.Rename(tmp.Name(), path)
+}
+
+func validID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
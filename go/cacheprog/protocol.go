@@ -0,0 +1,36 @@
+package cacheprog
+
+import "time"
+
+// The types in this file mirror the GOCACHEPROG protocol spoken by the go
+// command (see cmd/go/internal/cacheprog).
+
+type Cmd string
+
+const (
+	CmdGet   = Cmd("get")
+	CmdPut   = Cmd("put")
+	CmdClose = Cmd("close")
+)
+
+type Request struct {
+	ID       int64
+	Command  Cmd
+	ActionID []byte `json:",omitempty"`
+	OutputID []byte `json:",omitempty"`
+
+	// BodySize is the size of the body of a "put" request. The body itself
+	// follows the request as a separate base64-encoded JSON string.
+	BodySize int64 `json:",omitempty"`
+}
+
+type Response struct {
+	ID            int64
+	Err           string     `json:",omitempty"`
+	KnownCommands []Cmd      `json:",omitempty"`
+	Miss          bool       `json:",omitempty"`
+	OutputID      []byte     `json:",omitempty"`
+	Size          int64      `json:",omitempty"`
+	Time          *time.Time `json:",omitempty"`
+	DiskPath      string     `json:",omitempty"`
+}
@@ -0,0 +1,85 @@
+package cacheprog
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	outputIDHeader = "Go-Output-Id"
+	timeHeader     = "Go-Cache-Time"
+)
+
+// Server is an HTTP backend for the cache helper. It serves a single
+// resource:
+//
+//	GET /cache/<action-id>  returns the output body, with its output ID and
+//	                        creation time in the Go-Output-Id and
+//	                        Go-Cache-Time headers (or 404 on a miss)
+//	PUT /cache/<action-id>  stores the request body as the output, with the
+//	                        output ID given in the Go-Output-Id header
+//
+// All IDs are hex-encoded.
+type Server struct {
+	Dir string
+}
+
+func (s Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	actionID := strings.TrimPrefix(r.URL.Path, "/cache/")
+	if actionID == r.URL.Path || !validID(actionID) {
+		http.NotFound(w, r)
+		return
+	}
+	cache := diskCache{dir: s.Dir}
+
+	switch r.Method {
+	case http.MethodGet:
+		entry, ok := cache.get(actionID)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		file, err := os.Open(cache.outputPath(entry.OutputID))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer file.Close()
+
+		w.Header().Set(outputIDHeader, entry.OutputID)
+		w.Header().Set(timeHeader, entry.Time.Format(time.RFC3339Nano))
+		http.ServeContent(w, r, "", entry.Time, file)
+	case http.MethodPut:
+		outputID := r.Header.Get(outputIDHeader)
+		if _, err := cache.put(actionID, outputID, r.Body, time.Now(), false); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ServerMain runs the cache server, e.g. as a sidecar to builds. args are the
+// command-line arguments (excluding the program and subcommand names).
+func ServerMain(args []string) error {
+	flags := flag.NewFlagSet("cache-server", flag.ContinueOnError)
+	addr := flags.String("addr", ":8080", "address to listen on")
+	dir := flags.String("dir", "", "directory to store cache entries in")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return fmt.Errorf("-dir must be specified")
+	}
+
+	log.Printf("serving build cache from %s on %s", *dir, *addr)
+	return http.ListenAndServe(*addr, Server{Dir: *dir})
+}
@@ -0,0 +1,92 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+const contextLines = 3
+
+// Unified returns a unified diff between the old and new contents of a file,
+// suitable for use with `git apply`. path is relative to the root of the
+// repository (see RepoPrefix). A nil old or new represents a file that was created or deleted,
+// respectively. An empty string is returned when the contents are equal.
+func Unified(path string, old, new []byte) string {
+	if old != nil && new != nil && string(old) == string(new) {
+		return ""
+	}
+
+	oldName, newName := "a/"+path, "b/"+path
+	var header strings.Builder
+	fmt.Fprintf(&header, "diff --git a/%s b/%s\n", path, path)
+	switch {
+	case old == nil:
+		oldName = "/dev/null"
+		header.WriteString("new file mode 100644\n")
+	case new == nil:
+		newName = "/dev/null"
+		header.WriteString("deleted file mode 100644\n")
+	}
+	fmt.Fprintf(&header, "--- %s\n+++ %s\n", oldName, newName)
+
+	a, b := splitLines(string(old)), splitLines(string(new))
+	matcher := difflib.NewMatcherWithJunk(a, b, false, nil)
+
+	var out strings.Builder
+	out.WriteString(header.String())
+	for _, group := range matcher.GetGroupedOpCodes(contextLines) {
+		first, last := group[0], group[len(group)-1]
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(first.I1, last.I2), hunkRange(first.J1, last.J2))
+		for _, op := range group {
+			if op.Tag == 'e' {
+				writeLines(&out, " ", a[op.I1:op.I2])
+				continue
+			}
+			if op.Tag == 'r' || op.Tag == 'd' {
+				writeLines(&out, "-", a[op.I1:op.I2])
+			}
+			if op.Tag == 'r' || op.Tag == 'i' {
+				writeLines(&out, "+", b[op.J1:op.J2])
+			}
+		}
+	}
+	return out.String()
+}
+
+// splitLines splits s into lines, each including its trailing newline (if
+// any), so that a missing newline at the end of the file is preserved.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func writeLines(out *strings.Builder, prefix string, lines []string) {
+	for _, line := range lines {
+		out.WriteString(prefix)
+		out.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			out.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+func hunkRange(start, stop int) string {
+	// line numbers start at 1, and an empty range refers to the line before
+	beginning := start + 1
+	length := stop - start
+	if length == 1 {
+		return fmt.Sprintf("%d", beginning)
+	}
+	if length == 0 {
+		beginning--
+	}
+	return fmt.Sprintf("%d,%d", beginning, length)
+}
@@ -0,0 +1,125 @@
+package diff
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnified(t *testing.T) {
+	require.Equal(t, "", Unified("a.go", []byte("x\n"), []byte("x\n")))
+
+	require.Equal(t, `diff --git a/a.go b/a.go
+--- a/a.go
++++ b/a.go
+@@ -1,4 +1,4 @@
+ package a
+ 
+-func A()  {}
+-var x=1
+\ No newline at end of file
++func A() {}
++var x = 1
+`, Unified("a.go", []byte("package a\n\nfunc A()  {}\nvar x=1"), []byte("package a\n\nfunc A() {}\nvar x = 1\n")))
+
+	require.Equal(t, `diff --git a/b.go b/b.go
+new file mode 100644
+--- /dev/null
++++ b/b.go
+@@ -0,0 +1,2 @@
++package b
++// new
+`, Unified("b.go", nil, []byte("package b\n// new\n")))
+}
+
+func TestUnifiedGitApply(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	dir := t.TempDir()
+	old := "package a\n\nimport \"fmt\"\n\nfunc A() {\n\tfmt.Println(1)\n}\n\nfunc B()  {\n}\n\n\n\nfunc C() {}"
+	new := "package a\n\nimport \"fmt\"\n\nfunc A() {\n\tfmt.Println(1)\n}\n\nfunc B() {\n}\n\nfunc C() {}\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.go"), []byte(old), 0644))
+
+	patch := Unified("a.go", []byte(old), []byte(new)) +
+		Unified("sub/b.go", nil, []byte("package sub\n"))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "fmt.patch"), []byte(patch), 0644))
+
+	cmd := exec.Command("git", "apply", "fmt.patch")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	contents, err := os.ReadFile(filepath.Join(dir, "a.go"))
+	require.NoError(t, err)
+	require.Equal(t, new, string(contents))
+
+	contents, err = os.ReadFile(filepath.Join(dir, "sub", "b.go"))
+	require.NoError(t, err)
+	require.Equal(t, "package sub\n", string(contents))
+}
+
+func TestCompare(t *testing.T) {
+	before := Snapshot{
+		"a.go":       []byte("package a\n"),
+		"stale.go":   []byte("package a\n\nvar x = 1\n"),
+		"removed.go": []byte("package a\n"),
+	}
+	after := Snapshot{
+		"a.go":     []byte("package a\n"),
+		"stale.go": []byte("package a\n\nvar x = 2\n"),
+		"new.go":   []byte("package a\n"),
+	}
+
+	changes, patch := Compare(before, after, "")
+	require.Equal(t, []Change{
+		{Path: "new.go", Status: "added"},
+		{Path: "removed.go", Status: "deleted"},
+		{Path: "stale.go", Status: "modified"},
+	}, changes)
+	require.Equal(t, `diff --git a/new.go b/new.go
+new file mode 100644
+--- /dev/null
++++ b/new.go
+@@ -0,0 +1 @@
++package a
+diff --git a/removed.go b/removed.go
+deleted file mode 100644
+--- a/removed.go
++++ /dev/null
+@@ -1 +0,0 @@
+-package a
+diff --git a/stale.go b/stale.go
+--- a/stale.go
++++ b/stale.go
+@@ -1,3 +1,3 @@
+ package a
+ 
+-var x = 1
++var x = 2
+`, patch)
+}
+
+func TestRepoPrefix(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "repo", ".git"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "repo", "go", "mod"), 0755))
+
+	prefix, err := RepoPrefix(filepath.Join(dir, "repo", "go", "mod"))
+	require.NoError(t, err)
+	require.Equal(t, "go/mod", prefix)
+
+	prefix, err = RepoPrefix(filepath.Join(dir, "repo"))
+	require.NoError(t, err)
+	require.Equal(t, "", prefix)
+
+	before := Snapshot{"a.go": []byte("package a\n")}
+	after := Snapshot{"a.go": []byte("package b\n")}
+	changes, patch := Compare(before, after, "go/mod")
+	require.Equal(t, []Change{{Path: "a.go", Status: "modified"}}, changes)
+	require.Contains(t, patch, "diff --git a/go/mod/a.go b/go/mod/a.go\n")
+}
@@ -0,0 +1,36 @@
+package diff
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// RepoPrefix returns the slash-separated path of dir relative to the root of
+// the git repository containing it, or "" if dir is the root or isn't in a
+// repository. Patches with paths prefixed by it can be applied with `git
+// apply` from anywhere in the repository.
+func RepoPrefix(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("get absolute path: %w", err)
+	}
+	for root := dir; ; {
+		// .git is a file rather than a directory in worktrees and submodules
+		if _, err := os.Lstat(filepath.Join(root, ".git")); err == nil {
+			rel, err := filepath.Rel(root, dir)
+			if err != nil {
+				return "", err
+			}
+			if rel == "." {
+				return "", nil
+			}
+			return filepath.ToSlash(rel), nil
+		}
+		parent := filepath.Dir(root)
+		if parent == root {
+			return "", nil
+		}
+		root = parent
+	}
+}
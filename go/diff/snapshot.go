@@ -0,0 +1,103 @@
+package diff
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Snapshot is the contents of every file in a directory tree, keyed by
+// slash-separated path relative to the root.
+type Snapshot map[string][]byte
+
+type Change struct {
+	Path   string
+	Status string
+}
+
+// TakeSnapshot reads every regular file under dir. Directories for which skip
+// returns true are not descended into.
+func TakeSnapshot(dir string, skip func(rel string) bool) (Snapshot, error) {
+	snapshot := Snapshot{}
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if info.IsDir() {
+			if rel != "." && skip != nil && skip(rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		contents, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		snapshot[rel] = contents
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// Compare returns the files that were added, modified or deleted between the
+// two snapshots, along with a patch that applies the changes. The paths in the
+// patch are prefixed by prefix (see RepoPrefix).
+func Compare(before, after Snapshot, prefix string) ([]Change, string) {
+	paths := map[string]bool{}
+	for p := range before {
+		paths[p] = true
+	}
+	for p := range after {
+		paths[p] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+
+	var changes []Change
+	var patch strings.Builder
+	for _, p := range sorted {
+		old, inBefore := before[p]
+		new, inAfter := after[p]
+		var status string
+		switch {
+		case !inBefore:
+			status = "added"
+			old = nil
+			if new == nil {
+				new = []byte{}
+			}
+		case !inAfter:
+			status = "deleted"
+			new = nil
+			if old == nil {
+				old = []byte{}
+			}
+		case string(old) != string(new):
+			status = "modified"
+		default:
+			continue
+		}
+		changes = append(changes, Change{Path: p, Status: status})
+		patch.WriteString(Unified(path.Join(prefix, p), old, new))
+	}
+	return changes, patch.String()
+}
@@ -0,0 +1,127 @@
+package download
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/aoldershaw/prototype-experiments/go/build"
+	"github.com/aoldershaw/prototype-experiments/go/module"
+	"github.com/aoldershaw/prototype-sdk-go"
+)
+
+type Params struct {
+	build.Caches
+}
+
+type Module interface {
+	Execute(*exec.Cmd) error
+}
+
+// Download fetches every module in the build list into the module cache, and
+// exports the cache as a GOPROXY file tree. Later steps can then build without
+// network access by setting:
+//
+//	GOPROXY=file:///path/to/goproxy
+//	GOFLAGS=-mod=mod
+func Download(mod Module, params Params) ([]prototype.MessageResponse, error) {
+	gopathDir, gocacheDir, err := params.Caches.Prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	proxyDir := "./goproxy"
+	err = os.MkdirAll(proxyDir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create goproxy directory: %w", err)
+	}
+
+	modules, err := download(mod, gopathDir, gocacheDir, proxyDir)
+	PrintModules(modules)
+	if err != nil {
+		return nil, err
+	}
+
+	return []prototype.MessageResponse{{
+		Object: map[string]interface{}{
+			"goproxy": prototype.Artifact(proxyDir),
+			"gopath":  prototype.Artifact(gopathDir),
+			"gocache": prototype.Artifact(gocacheDir),
+		},
+	}}, nil
+}
+
+func download(mod Module, gopathDir, gocacheDir, proxyDir string) ([]module.Version, error) {
+	// get absolute paths since go command runs in a different directory
+	gopathDir, err := filepath.Abs(gopathDir)
+	if err != nil {
+		return nil, fmt.Errorf("get absolute path: %w", err)
+	}
+	gocacheDir, err = filepath.Abs(gocacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("get absolute path: %w", err)
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.Command("go", "mod", "download", "-json", "all")
+	cmd.Env = append(build.CacheEnv(gopathDir, gocacheDir), "GOFLAGS=-mod=mod")
+	cmd.Stdout = &stdout
+	runErr := mod.Execute(cmd)
+
+	modules, err := module.ParseVersions(&stdout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse go mod download output: %w", err)
+	}
+
+	var failed []string
+	for _, m := range modules {
+		if m.Error != "" {
+			failed = append(failed, m.String())
+		}
+	}
+	if len(failed) > 0 {
+		return modules, fmt.Errorf("failed to download %s", strings.Join(failed, ", "))
+	}
+	if runErr != nil {
+		return modules, fmt.Errorf("go mod download failed: %w", runErr)
+	}
+
+	downloadDir := filepath.Join(gopathDir, "pkg", "mod", "cache", "download")
+	if err := exportProxy(downloadDir, proxyDir); err != nil {
+		return modules, fmt.Errorf("failed to export goproxy: %w", err)
+	}
+
+	return modules, nil
+}
+
+// exportProxy copies the module download cache (which is laid out as a
+// GOPROXY file tree) to dst. Lock files, partial downloads and the checksum
+// database cache are left behind, as they aren't part of the proxy protocol.
+func exportProxy(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == src {
+			// nothing to download (e.g. no dependencies)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if rel == "sumdb" {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+		if !info.Mode().IsRegular() || strings.HasSuffix(path, ".lock") || strings.HasSuffix(path, ".partial") {
+			return nil
+		}
+		return module.CopyFile(path, filepath.Join(dst, rel), 0644)
+	})
+}
@@ -0,0 +1,113 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aoldershaw/prototype-experiments/go/module"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeModule struct {
+	stdout string
+	files  []string
+	err    error
+
+	args [][]string
+	envs [][]string
+}
+
+// Execute simulates `go mod download` by writing files into the module cache.
+func (m *fakeModule) Execute(cmd *exec.Cmd) error {
+	m.args = append(m.args, cmd.Args)
+	m.envs = append(m.envs, cmd.Env)
+
+	var gopath string
+	for _, kv := range cmd.Env {
+		if strings.HasPrefix(kv, "GOPATH=") {
+			gopath = strings.TrimPrefix(kv, "GOPATH=")
+		}
+	}
+	for _, file := range m.files {
+		path := filepath.Join(gopath, "pkg", "mod", "cache", "download", file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, []byte(file), 0644); err != nil {
+			return err
+		}
+	}
+	fmt.Fprint(cmd.Stdout, m.stdout)
+	return m.err
+}
+
+func TestDownload(t *testing.T) {
+	gopathDir := t.TempDir()
+	gocacheDir := t.TempDir()
+	proxyDir := t.TempDir()
+
+	mod := &fakeModule{
+		stdout: `{"Path": "golang.org/x/mod", "Version": "v0.40.0"}
+{"Path": "github.com/stretchr/testify", "Version": "v1.8.4"}`,
+		files: []string{
+			"golang.org/x/mod/@v/list",
+			"golang.org/x/mod/@v/v0.40.0.info",
+			"golang.org/x/mod/@v/v0.40.0.mod",
+			"golang.org/x/mod/@v/v0.40.0.zip",
+			"golang.org/x/mod/@v/v0.40.0.lock",
+			"github.com/stretchr/testify/@v/v1.8.4.mod",
+			"github.com/stretchr/testify/@v/v1.8.4.zip.partial",
+			"sumdb/sum.golang.org/lookup/golang.org/x/mod@v0.40.0",
+		},
+	}
+
+	modules, err := download(mod, gopathDir, gocacheDir, proxyDir)
+	require.NoError(t, err)
+	require.Equal(t, []module.Version{
+		{Path: "github.com/stretchr/testify", Version: "v1.8.4"},
+		{Path: "golang.org/x/mod", Version: "v0.40.0"},
+	}, modules)
+
+	require.Equal(t, [][]string{{"go", "mod", "download", "-json", "all"}}, mod.args)
+	require.Equal(t, [][]string{{
+		"GOPATH=" + gopathDir,
+		"GOCACHE=" + gocacheDir,
+		"GOFLAGS=-mod=mod",
+	}}, mod.envs)
+
+	var exported []string
+	err = filepath.Walk(proxyDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(proxyDir, path)
+		exported = append(exported, filepath.ToSlash(rel))
+		return err
+	})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{
+		"golang.org/x/mod/@v/list",
+		"golang.org/x/mod/@v/v0.40.0.info",
+		"golang.org/x/mod/@v/v0.40.0.mod",
+		"golang.org/x/mod/@v/v0.40.0.zip",
+		"github.com/stretchr/testify/@v/v1.8.4.mod",
+	}, exported)
+}
+
+func TestDownloadFailure(t *testing.T) {
+	mod := &fakeModule{
+		stdout: `{"Path": "golang.org/x/mod", "Version": "v0.40.0"}
+{"Path": "example.com/missing", "Version": "v1.0.0", "Error": "not found"}`,
+		err: module.ExecutionError{Err: errors.New("exit status 1")},
+	}
+
+	modules, err := download(mod, t.TempDir(), t.TempDir(), t.TempDir())
+	require.EqualError(t, err, "failed to download example.com/missing@v1.0.0")
+	require.Len(t, modules, 2)
+}
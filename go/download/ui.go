@@ -0,0 +1,21 @@
+package download
+
+import (
+	"fmt"
+
+	"github.com/aoldershaw/prototype-experiments/go/module"
+)
+
+func PrintModules(modules []module.Version) {
+	var failed int
+	for _, m := range modules {
+		if m.Error == "" {
+			fmt.Printf("--> %s ... \x1b[32mdownloaded\x1b[0m\n", m)
+		} else {
+			failed++
+			fmt.Printf("--> %s ... \x1b[31mfailed\x1b[0m\n", m)
+			fmt.Printf("    %s\n", m.Error)
+		}
+	}
+	fmt.Printf("\n%d module(s) downloaded, %d failed\n", len(modules)-failed, failed)
+}
@@ -0,0 +1,136 @@
+package generate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aoldershaw/prototype-experiments/go/build"
+	"github.com/aoldershaw/prototype-experiments/go/diff"
+	"github.com/aoldershaw/prototype-experiments/go/module"
+	"github.com/aoldershaw/prototype-sdk-go"
+)
+
+type Params struct {
+	Package build.OneOrMany `json:"package"`
+
+	Run  string   `json:"run"`
+	Skip string   `json:"skip"`
+	Tags []string `json:"tags"`
+
+	build.Caches
+}
+
+type Module interface {
+	Execute(*exec.Cmd) error
+	Info() (module.Info, error)
+}
+
+func Generate(mod Module, params Params) ([]prototype.MessageResponse, error) {
+	patchDir := "./patch"
+	err := os.MkdirAll(patchDir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create patch directory: %w", err)
+	}
+
+	gopathDir, gocacheDir, err := params.Caches.Prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	changes, patch, err := generate(mod, params, gopathDir, gocacheDir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ioutil.WriteFile(filepath.Join(patchDir, "generate.patch"), []byte(patch), 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write patch: %w", err)
+	}
+
+	PrintChanges(changes)
+
+	responses := []prototype.MessageResponse{{
+		Object: map[string]interface{}{
+			"patch":   prototype.Artifact(patchDir),
+			"gopath":  prototype.Artifact(gopathDir),
+			"gocache": prototype.Artifact(gocacheDir),
+		},
+	}}
+
+	if len(changes) > 0 {
+		return module.Fail(responses, fmt.Errorf("%d generated file(s) are out of date", len(changes)))
+	}
+
+	return responses, nil
+}
+
+// generate runs `go generate` in the module and returns the files that it
+// changed.
+func generate(mod Module, params Params, gopathDir, gocacheDir string) ([]diff.Change, string, error) {
+	// get absolute paths since go command runs in a different directory
+	gopathDir, err := filepath.Abs(gopathDir)
+	if err != nil {
+		return nil, "", fmt.Errorf("get absolute path: %w", err)
+	}
+	gocacheDir, err = filepath.Abs(gocacheDir)
+	if err != nil {
+		return nil, "", fmt.Errorf("get absolute path: %w", err)
+	}
+
+	info, err := mod.Info()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get module info: %w", err)
+	}
+
+	before, err := diff.TakeSnapshot(info.Dir, skipDir)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to snapshot module: %w", err)
+	}
+
+	if len(params.Package) == 0 {
+		params.Package = build.OneOrMany{"./..."}
+	}
+
+	cmd := exec.Command("go", "generate")
+	if params.Run != "" {
+		cmd.Args = append(cmd.Args, "-run", params.Run)
+	}
+	if params.Skip != "" {
+		cmd.Args = append(cmd.Args, "-skip", params.Skip)
+	}
+	if len(params.Tags) > 0 {
+		cmd.Args = append(cmd.Args, "-tags", strings.Join(params.Tags, ","))
+	}
+	cmd.Args = append(cmd.Args, params.Package...)
+
+	// generators are arbitrary commands (e.g. stringer, mockgen), so they
+	// need the full environment to be found on the PATH
+	cmd.Env = append(os.Environ(), build.CacheEnv(gopathDir, gocacheDir)...)
+	cmd.Stdout = os.Stdout
+
+	if err := mod.Execute(cmd); err != nil {
+		return nil, "", fmt.Errorf("go generate failed: %w", err)
+	}
+
+	after, err := diff.TakeSnapshot(info.Dir, skipDir)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to snapshot module: %w", err)
+	}
+
+	prefix, err := diff.RepoPrefix(info.Dir)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to locate repository root: %w", err)
+	}
+
+	changes, patch := diff.Compare(before, after, prefix)
+	return changes, patch, nil
+}
+
+func skipDir(rel string) bool {
+	return path.Base(rel) == ".git"
+}
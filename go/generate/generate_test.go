@@ -0,0 +1,128 @@
+package generate
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/aoldershaw/prototype-experiments/go/build"
+	"github.com/aoldershaw/prototype-experiments/go/diff"
+	"github.com/aoldershaw/prototype-experiments/go/module"
+	"github.com/aoldershaw/prototype-sdk-go"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeModule struct {
+	dir      string
+	generate func(dir string) error
+	args     [][]string
+	envs     [][]string
+}
+
+func (m *fakeModule) Info() (module.Info, error) {
+	return module.Info{Path: "example.com/gt", Dir: m.dir}, nil
+}
+
+func (m *fakeModule) Execute(cmd *exec.Cmd) error {
+	m.args = append(m.args, cmd.Args)
+	m.envs = append(m.envs, cmd.Env)
+	return m.generate(m.dir)
+}
+
+func TestGenerate(t *testing.T) {
+	for _, tt := range []struct {
+		desc     string
+		params   Params
+		generate func(dir string) error
+		args     []string
+		changes  []diff.Change
+	}{
+		{
+			desc:   "up to date",
+			params: Params{},
+			generate: func(dir string) error {
+				return ioutil.WriteFile(filepath.Join(dir, "a_string.go"), []byte("package a\n"), 0644)
+			},
+			args: []string{"go", "generate", "./..."},
+		},
+		{
+			desc: "drift",
+			params: Params{
+				Package: build.OneOrMany{"./a"},
+				Run:     "stringer",
+				Skip:    "mockgen",
+				Tags:    []string{"foo"},
+			},
+			generate: func(dir string) error {
+				err := ioutil.WriteFile(filepath.Join(dir, "a_string.go"), []byte("package a\n\nvar x = 1\n"), 0644)
+				if err != nil {
+					return err
+				}
+				if err := os.MkdirAll(filepath.Join(dir, "mocks"), 0755); err != nil {
+					return err
+				}
+				return ioutil.WriteFile(filepath.Join(dir, "mocks", "fake.go"), []byte("package mocks\n"), 0644)
+			},
+			args: []string{"go", "generate", "-run", "stringer", "-skip", "mockgen", "-tags", "foo", "./a"},
+			changes: []diff.Change{
+				{Path: "a_string.go", Status: "modified"},
+				{Path: "mocks/fake.go", Status: "added"},
+			},
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			dir := t.TempDir()
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a_string.go"), []byte("package a\n"), 0644))
+			require.NoError(t, os.MkdirAll(filepath.Join(dir, ".git"), 0755))
+			require.NoError(t, ioutil.WriteFile(filepath.Join(dir, ".git", "index"), []byte("x"), 0644))
+
+			mod := &fakeModule{dir: dir, generate: func(dir string) error {
+				// changes to .git should be ignored
+				err := ioutil.WriteFile(filepath.Join(dir, ".git", "index"), []byte("y"), 0644)
+				if err != nil {
+					return err
+				}
+				return tt.generate(dir)
+			}}
+			changes, patch, err := generate(mod, tt.params, "/gopath", "/gocache")
+			require.NoError(t, err)
+			require.Equal(t, [][]string{tt.args}, mod.args)
+			require.Contains(t, mod.envs[0], "GOPATH=/gopath")
+			require.Contains(t, mod.envs[0], "GOCACHE=/gocache")
+			require.Equal(t, tt.changes, changes)
+			if len(changes) == 0 {
+				require.Empty(t, patch)
+			}
+		})
+	}
+}
+
+func TestGenerateDrift(t *testing.T) {
+	repo := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(repo, ".git"), 0755))
+	dir := filepath.Join(repo, "go")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a_string.go"), []byte("package a\n"), 0644))
+	t.Chdir(t.TempDir())
+
+	mod := &fakeModule{dir: dir, generate: func(dir string) error {
+		return ioutil.WriteFile(filepath.Join(dir, "a_string.go"), []byte("package a\n\nvar x = 1\n"), 0644)
+	}}
+	responses, err := Generate(mod, Params{})
+	require.EqualError(t, err, "1 generated file(s) are out of date")
+
+	// the patch is published even though the message fails
+	require.Equal(t, []prototype.MessageResponse{{
+		Object: map[string]interface{}{
+			"patch":   prototype.Artifact("./patch"),
+			"gopath":  prototype.Artifact("./gopath"),
+			"gocache": prototype.Artifact("./gocache"),
+		},
+	}}, responses)
+
+	patch, err := ioutil.ReadFile(filepath.Join("patch", "generate.patch"))
+	require.NoError(t, err)
+	require.Contains(t, string(patch), "diff --git a/go/a_string.go b/go/a_string.go\n")
+}
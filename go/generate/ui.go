@@ -0,0 +1,24 @@
+package generate
+
+import (
+	"fmt"
+
+	"github.com/aoldershaw/prototype-experiments/go/diff"
+)
+
+func PrintChanges(changes []diff.Change) {
+	if len(changes) == 0 {
+		fmt.Println("\x1b[32mgenerated files are up to date\x1b[0m")
+		return
+	}
+
+	for _, change := range changes {
+		fmt.Printf("--> %s ... \x1b[31m%s\x1b[0m\n", change.Path, change.Status)
+	}
+
+	filesWord := "files"
+	if len(changes) == 1 {
+		filesWord = "file"
+	}
+	fmt.Printf("\n\x1b[1m%d generated %s changed after running go generate\x1b[0m\n", len(changes), filesWord)
+}
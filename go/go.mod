@@ -1,9 +1,28 @@
 module github.com/aoldershaw/prototype-experiments/go
 
-go 1.16
+go 1.25.0
 
 require (
+	aead.dev/minisign v0.2.0
+	github.com/ProtonMail/go-crypto v1.3.0
 	github.com/aoldershaw/prototype-sdk-go v0.0.0-20210507184418-7d65e7b0898f
-	github.com/mitchellh/reflectwalk v1.0.2 // indirect
+	github.com/klauspost/compress v1.18.0
+	github.com/klauspost/pgzip v1.2.6
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/stretchr/testify v1.7.0
+	github.com/ulikunitz/xz v0.5.15
+	golang.org/x/crypto v0.54.0
+	golang.org/x/mod v0.37.0
+	golang.org/x/tools v0.47.0
+	lukechampine.com/blake3 v1.4.1
+)
+
+require (
+	github.com/cloudflare/circl v1.6.1 // indirect
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/klauspost/cpuid/v2 v2.0.12 // indirect
+	github.com/mitchellh/reflectwalk v1.0.2 // indirect
+	golang.org/x/sync v0.21.0 // indirect
+	golang.org/x/sys v0.47.0 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c // indirect
 )
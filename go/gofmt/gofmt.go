@@ -0,0 +1,203 @@
+package gofmt
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aoldershaw/prototype-experiments/go/diff"
+	"github.com/aoldershaw/prototype-experiments/go/module"
+	"github.com/aoldershaw/prototype-sdk-go"
+)
+
+type Params struct {
+	// Exclude is a list of glob patterns (see path.Match) of files and
+	// directories to skip, relative to the module directory. Patterns are
+	// matched against both the relative path and the base name.
+	Exclude       []string `json:"exclude"`
+	SkipGenerated bool     `json:"skip_generated"`
+
+	// Goimports formats files with goimports rather than gofmt, which also
+	// adds missing imports, removes unused ones, and groups imports with
+	// LocalPrefix after third-party imports.
+	Goimports   bool   `json:"goimports"`
+	LocalPrefix string `json:"local_prefix"`
+}
+
+type Module interface {
+	Execute(*exec.Cmd) error
+	Info() (module.Info, error)
+}
+
+func Fmt(mod Module, params Params) ([]prototype.MessageResponse, error) {
+	patchDir := "./patch"
+	err := os.MkdirAll(patchDir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create patch directory: %w", err)
+	}
+
+	info, err := mod.Info()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get module info: %w", err)
+	}
+
+	prefix, err := diff.RepoPrefix(info.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate repository root: %w", err)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate goimports: %w", err)
+	}
+
+	files, patch, err := check(mod, executable, info.Dir, prefix, params)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ioutil.WriteFile(filepath.Join(patchDir, "fmt.patch"), []byte(patch), 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write patch: %w", err)
+	}
+
+	PrintResult(files, patch)
+
+	responses := []prototype.MessageResponse{{
+		Object: map[string]interface{}{
+			"patch": prototype.Artifact(patchDir),
+		},
+	}}
+
+	if len(files) > 0 {
+		return module.Fail(responses, fmt.Errorf("%d file(s) need formatting", len(files)))
+	}
+
+	return responses, nil
+}
+
+// check formats every Go file in dir (the directory of mod), returning the
+// files (relative to dir) that would change, and a patch to apply the changes.
+// The paths in the patch are prefixed by prefix, the path of dir in its
+// repository (see diff.RepoPrefix). With goimports, the files are formatted by
+// running executable (see GoimportsMain).
+func check(mod Module, executable, dir, prefix string, params Params) ([]string, string, error) {
+	type source struct {
+		rel, path string
+		src       []byte
+	}
+	var sources []source
+
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if info.IsDir() {
+			if rel == "." {
+				return nil
+			}
+			if skipDir(filePath, info.Name()) || excluded(rel, params.Exclude) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(rel, ".go") || excluded(rel, params.Exclude) {
+			return nil
+		}
+
+		src, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", rel, err)
+		}
+		if params.SkipGenerated && isGenerated(src) {
+			return nil
+		}
+
+		sources = append(sources, source{rel: rel, path: filePath, src: src})
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	var formatted map[string][]byte
+	if params.Goimports && len(sources) > 0 {
+		var paths []string
+		for _, s := range sources {
+			paths = append(paths, s.path)
+		}
+		formatted, err = goimports(mod, executable, paths, params.LocalPrefix)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	var files []string
+	var patch strings.Builder
+	for _, s := range sources {
+		var out []byte
+		if params.Goimports {
+			var ok bool
+			if out, ok = formatted[s.path]; !ok {
+				return nil, "", fmt.Errorf("goimports didn't format %s", s.rel)
+			}
+		} else {
+			out, err = format.Source(s.src)
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to format %s: %w", s.rel, err)
+			}
+		}
+		if !bytes.Equal(s.src, out) {
+			files = append(files, s.rel)
+			patch.WriteString(diff.Unified(path.Join(prefix, s.rel), s.src, out))
+		}
+	}
+
+	return files, patch.String(), nil
+}
+
+// skipDir returns whether the directory would be ignored by the go command
+// when matching "./...", or is the root of a nested module.
+func skipDir(dirPath, name string) bool {
+	if name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(dirPath, "go.mod"))
+	return err == nil
+}
+
+func excluded(rel string, patterns []string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(pattern, "/")
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, path.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func isGenerated(src []byte) bool {
+	file, err := parser.ParseFile(token.NewFileSet(), "", src, parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil {
+		return false
+	}
+	return ast.IsGenerated(file)
+}
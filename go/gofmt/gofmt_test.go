@@ -0,0 +1,202 @@
+package gofmt
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/aoldershaw/prototype-experiments/go/module"
+	"github.com/aoldershaw/prototype-sdk-go"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMain runs GoimportsMain when the test binary is run as goimports,
+// standing in for the prototype.
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == "goimports" {
+		if err := GoimportsMain(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+type fakeModule struct {
+	dir string
+}
+
+func (m fakeModule) Execute(cmd *exec.Cmd) error {
+	cmd.Dir = m.dir
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func (m fakeModule) Info() (module.Info, error) {
+	return module.Info{Path: "example.com/gt", Dir: m.dir}, nil
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, contents := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0644))
+	}
+}
+
+func TestCheck(t *testing.T) {
+	const unformatted = "package a\nfunc A()  {}\n"
+	const generated = "// Code generated by stringer. DO NOT EDIT.\n\npackage a\nvar  x = 1\n"
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":                 "module example.com/gt\n",
+		"ok.go":                  "package a\n\nfunc A() {}\n",
+		"a/bad.go":               unformatted,
+		"a/gen.go":               generated,
+		"a/mocks/mock.go":        unformatted,
+		"a/b/c.pb.go":            unformatted,
+		"vendor/x/x.go":          unformatted,
+		"testdata/x.go":          unformatted,
+		".hidden/x.go":           unformatted,
+		"nested/go.mod":          "module example.com/nested\n",
+		"nested/x.go":            unformatted,
+		"imports/imports.go":     "package imports\n\nimport (\n\t\"example.com/gt/x\"\n\t\"fmt\"\n)\n\nvar _ = fmt.Println\nvar _ = x.X\n",
+		"imports/missing.go":     "package imports\n\nvar _ = strings.TrimSpace\n",
+		"imports/unused.go":      "package imports\n\nimport \"os\"\n",
+		"imports/ok.go":          "package imports\n\nimport \"os\"\n\nvar _ = os.Exit\n",
+		"local/local.go":         "package local\n\nimport (\n\t\"fmt\"\n\n\t\"example.com/gt/x\"\n\t\"example.com/other/y\"\n)\n\nvar _ = fmt.Println\nvar _ = x.X\nvar _ = y.Y\n",
+		"not-go/readme.md":       unformatted,
+		"a/mocks/other/other.go": unformatted,
+	})
+
+	for _, tt := range []struct {
+		desc   string
+		params Params
+		files  []string
+	}{
+		{
+			desc:   "defaults",
+			params: Params{},
+			files: []string{
+				"a/b/c.pb.go",
+				"a/bad.go",
+				"a/gen.go",
+				"a/mocks/mock.go",
+				"a/mocks/other/other.go",
+			},
+		},
+		{
+			desc: "exclusions",
+			params: Params{
+				Exclude:       []string{"a/mocks/", "*.pb.go"},
+				SkipGenerated: true,
+			},
+			files: []string{
+				"a/bad.go",
+			},
+		},
+		{
+			desc: "goimports",
+			params: Params{
+				Exclude:   []string{"a"},
+				Goimports: true,
+			},
+			files: []string{
+				"imports/imports.go",
+				"imports/missing.go",
+				"imports/unused.go",
+			},
+		},
+		{
+			desc: "goimports with local prefix",
+			params: Params{
+				Exclude:     []string{"a", "imports"},
+				Goimports:   true,
+				LocalPrefix: "example.com/gt",
+			},
+			files: []string{
+				"local/local.go",
+			},
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			executable, err := os.Executable()
+			require.NoError(t, err)
+			files, patch, err := check(fakeModule{dir: dir}, executable, dir, "", tt.params)
+			require.NoError(t, err)
+			require.Equal(t, tt.files, files)
+			for _, file := range files {
+				require.Contains(t, patch, "diff --git a/"+file+" b/"+file+"\n")
+			}
+		})
+	}
+}
+
+func TestFmt(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":   "module example.com/gt\n",
+		"ok.go":    "package a\n\nfunc A() {}\n",
+		"a/bad.go": "package a\nfunc A()  {}\n",
+	})
+	t.Chdir(t.TempDir())
+
+	responses, fmtErr := Fmt(fakeModule{dir: dir}, Params{})
+	require.EqualError(t, fmtErr, "1 file(s) need formatting")
+
+	// the patch is published even though the message fails
+	require.Equal(t, []prototype.MessageResponse{{
+		Object: map[string]interface{}{
+			"patch": prototype.Artifact("./patch"),
+		},
+	}}, responses)
+	patch, err := ioutil.ReadFile(filepath.Join("patch", "fmt.patch"))
+	require.NoError(t, err)
+	require.Contains(t, string(patch), "diff --git a/a/bad.go b/a/bad.go\n")
+
+	failureResponses, ok := module.FailureResponses(fmtErr)
+	require.True(t, ok)
+	require.Equal(t, responses, failureResponses)
+}
+
+func TestFmtGitApply(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	repo := t.TempDir()
+	out, err := exec.Command("git", "init", "-q", repo).CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	// the module is in a subdirectory of the repository, so the patch must
+	// be relative to the root of the repository for `git apply`
+	dir := filepath.Join(repo, "go")
+	writeFiles(t, dir, map[string]string{
+		"go.mod":   "module example.com/gt\n",
+		"a/bad.go": "package a\nfunc A()  {}\n",
+	})
+	t.Chdir(t.TempDir())
+
+	_, err = Fmt(fakeModule{dir: dir}, Params{})
+	require.EqualError(t, err, "1 file(s) need formatting")
+
+	patchPath, err := filepath.Abs(filepath.Join("patch", "fmt.patch"))
+	require.NoError(t, err)
+	patch, err := ioutil.ReadFile(patchPath)
+	require.NoError(t, err)
+	require.Contains(t, string(patch), "diff --git a/go/a/bad.go b/go/a/bad.go\n")
+
+	cmd := exec.Command("git", "apply", patchPath)
+	cmd.Dir = repo
+	out, err = cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	contents, err := ioutil.ReadFile(filepath.Join(dir, "a", "bad.go"))
+	require.NoError(t, err)
+	require.Equal(t, "package a\n\nfunc A() {}\n", string(contents))
+}
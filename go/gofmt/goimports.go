@@ -0,0 +1,76 @@
+package gofmt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/tools/imports"
+)
+
+// GoimportsMain formats the files listed on stdin (one path per line) with
+// goimports, writing a JSON object mapping each path to its formatted
+// contents to stdout.
+//
+// goimports resolves missing imports from the module in the working
+// directory, and groups imports by the imports.LocalPrefix global, so it's
+// run as a subcommand of the prototype in the module directory rather than
+// changing the state of the prototype process.
+func GoimportsMain(args []string) error {
+	flags := flag.NewFlagSet("goimports", flag.ContinueOnError)
+	local := flags.String("local", "", "comma-separated import path prefixes to group after third-party imports")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	imports.LocalPrefix = *local
+
+	formatted := map[string]string{}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		path := scanner.Text()
+		if path == "" {
+			continue
+		}
+		out, err := imports.Process(path, nil, &imports.Options{
+			Comments:  true,
+			TabIndent: true,
+			TabWidth:  8,
+		})
+		if err != nil {
+			return err
+		}
+		formatted[path] = string(out)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return json.NewEncoder(os.Stdout).Encode(formatted)
+}
+
+// goimports formats the files at paths by running GoimportsMain through
+// executable (the prototype) in the module, returning the formatted contents
+// of each path.
+func goimports(mod Module, executable string, paths []string, localPrefix string) (map[string][]byte, error) {
+	var stdout bytes.Buffer
+	cmd := exec.Command(executable, "goimports", "-local", localPrefix)
+	cmd.Stdin = strings.NewReader(strings.Join(paths, "\n"))
+	cmd.Stdout = &stdout
+	if err := mod.Execute(cmd); err != nil {
+		return nil, fmt.Errorf("goimports failed: %w", err)
+	}
+
+	var formatted map[string]string
+	if err := json.Unmarshal(stdout.Bytes(), &formatted); err != nil {
+		return nil, fmt.Errorf("failed to parse goimports output: %w", err)
+	}
+	out := make(map[string][]byte, len(formatted))
+	for path, contents := range formatted {
+		out[path] = []byte(contents)
+	}
+	return out, nil
+}
@@ -0,0 +1,18 @@
+package gofmt
+
+import (
+	"fmt"
+)
+
+func PrintResult(files []string, patch string) {
+	if len(files) == 0 {
+		fmt.Println("\x1b[32mall files formatted\x1b[0m")
+		return
+	}
+
+	for _, file := range files {
+		fmt.Printf("--> %s ... \x1b[31mneeds formatting\x1b[0m\n", file)
+	}
+
+	fmt.Printf("\n\x1b[1mapply the following patch with `git apply`:\x1b[0m\n\n%s", patch)
+}
@@ -1,21 +1,109 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
+	"os"
 
 	"github.com/aoldershaw/prototype-experiments/go/build"
+	"github.com/aoldershaw/prototype-experiments/go/cacheprog"
+	"github.com/aoldershaw/prototype-experiments/go/download"
+	"github.com/aoldershaw/prototype-experiments/go/generate"
+	"github.com/aoldershaw/prototype-experiments/go/gofmt"
 	"github.com/aoldershaw/prototype-experiments/go/module"
+	"github.com/aoldershaw/prototype-experiments/go/test"
+	"github.com/aoldershaw/prototype-experiments/go/tidy"
+	"github.com/aoldershaw/prototype-experiments/go/vet"
 	"github.com/aoldershaw/prototype-sdk-go"
 )
 
 func main() {
+	if vet.IsToolInvocation(os.Args) {
+		vet.RunTool()
+	}
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "cacheprog":
+			if err := cacheprog.Main(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "cache-server":
+			if err := cacheprog.ServerMain(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		case "goimports":
+			if err := gofmt.GoimportsMain(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	proto := prototype.New(
 		prototype.WithIcon("mdi:language-go"),
 		prototype.WithObject(module.Module{},
 			prototype.WithMessage("build", build.Build),
+			prototype.WithMessage("test", test.Test),
+			prototype.WithMessage("vet", vet.Vet),
+			prototype.WithMessage("fmt", gofmt.Fmt),
+			prototype.WithMessage("generate", generate.Generate),
+			prototype.WithMessage("tidy", tidy.Tidy),
+			prototype.WithMessage("download", download.Download),
 		),
 	)
-	if err := proto.Execute(); err != nil {
+	if err := execute(proto); err != nil {
 		log.Fatal(err)
 	}
 }
+
+// execute is like proto.Execute, except that messages failing with a
+// module.Failure still write their responses (e.g. reports of the failure)
+// before exiting non-zero.
+func execute(proto prototype.Prototype) error {
+	if len(os.Args) <= 1 {
+		return proto.Execute()
+	}
+
+	var request struct {
+		prototype.MessageRequest
+		ResponsePath string `json:"response_path"`
+	}
+	if err := json.NewDecoder(os.Stdin).Decode(&request); err != nil {
+		return fmt.Errorf("invalid json request: %w", err)
+	}
+
+	message := os.Args[1]
+	responses, runErr := proto.Run(message, request.MessageRequest)
+	if runErr != nil {
+		var ok bool
+		responses, ok = module.FailureResponses(runErr)
+		if !ok {
+			return fmt.Errorf("run %q: %w", message, runErr)
+		}
+	}
+
+	responseFile, err := os.OpenFile(request.ResponsePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("open response file: %w", err)
+	}
+	defer responseFile.Close()
+
+	encoder := json.NewEncoder(responseFile)
+	for _, response := range responses {
+		if err := encoder.Encode(response); err != nil {
+			return fmt.Errorf("write response: %w", err)
+		}
+	}
+	if err := responseFile.Close(); err != nil {
+		return fmt.Errorf("write response: %w", err)
+	}
+
+	if runErr != nil {
+		return fmt.Errorf("run %q: %w", message, runErr)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package module
+
+import (
+	"errors"
+
+	"github.com/aoldershaw/prototype-sdk-go"
+)
+
+// Failure is an error returned by a message that failed, but still has
+// outputs to publish, such as reports or patches describing the failure.
+type Failure struct {
+	Responses []prototype.MessageResponse
+	Err       error
+}
+
+// Fail returns the responses along with a Failure wrapping err, so that the
+// responses are written even though the message fails.
+func Fail(responses []prototype.MessageResponse, err error) ([]prototype.MessageResponse, error) {
+	return responses, Failure{Responses: responses, Err: err}
+}
+
+func (f Failure) Error() string {
+	return f.Err.Error()
+}
+
+func (f Failure) Unwrap() error {
+	return f.Err
+}
+
+// FailureResponses returns the responses of the Failure in err's chain, if
+// any.
+func FailureResponses(err error) ([]prototype.MessageResponse, bool) {
+	var failure Failure
+	if !errors.As(err, &failure) {
+		return nil, false
+	}
+	return failure.Responses, true
+}
@@ -2,25 +2,84 @@ package module
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
 	"strings"
+	"syscall"
 )
 
 type Module struct {
 	Path string `json:"module" prototype:"required"`
 }
 
+type Info struct {
+	Path string
+	Dir  string
+}
+
 type Package struct {
 	Name       string
 	ImportPath string
 }
 
-// ResolvePackages returns the import paths to the packages that are "main"
-// packages, from the list of packages given. The list of packages can include
-// relative paths, the special "..." Go keyword, etc.
+// Version is a module version, as reported by `go mod download -json`.
+type Version struct {
+	Path    string
+	Version string
+	Error   string
+}
+
+func (v Version) String() string {
+	return v.Path + "@" + v.Version
+}
+
+// ParseVersions parses the stream of JSON objects written by `go mod download
+// -json`, sorted by path and version.
+func ParseVersions(r io.Reader) ([]Version, error) {
+	var versions []Version
+	decoder := json.NewDecoder(r)
+	for {
+		var v Version
+		err := decoder.Decode(&v)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		if versions[i].Path != versions[j].Path {
+			return versions[i].Path < versions[j].Path
+		}
+		return versions[i].Version < versions[j].Version
+	})
+	return versions, nil
+}
+
+// Executor runs commands in a module.
+type Executor interface {
+	Execute(*exec.Cmd) error
+}
+
+// ResolvePackages returns the packages matching the list of packages given.
+// The list of packages can include relative paths, the special "..." Go
+// keyword, etc.
 func (m Module) ResolvePackages(packages ...string) ([]Package, error) {
+	return ResolvePackages(m, packages...)
+}
+
+// ResolvePackages is like Module.ResolvePackages, but runs `go list` with e,
+// which may e.g. modify its environment.
+func ResolvePackages(e Executor, packages ...string) ([]Package, error) {
 	args := make([]string, 0, len(packages)+3)
 	args = append(args, "list", "-f", "{{.Name}}|{{.ImportPath}}")
 	args = append(args, packages...)
@@ -29,7 +88,7 @@ func (m Module) ResolvePackages(packages ...string) ([]Package, error) {
 	cmd := exec.Command("go", args...)
 	cmd.Stdout = &buf
 
-	err := m.Execute(cmd)
+	err := e.Execute(cmd)
 	if err != nil {
 		return nil, err
 	}
@@ -47,27 +106,117 @@ func (m Module) ResolvePackages(packages ...string) ([]Package, error) {
 			continue
 		}
 
-		if parts[0] == "main" {
-			results = append(results, Package{
-				Name:       parts[0],
-				ImportPath: parts[1],
-			})
-		}
+		results = append(results, Package{
+			Name:       parts[0],
+			ImportPath: parts[1],
+		})
 	}
 
 	return results, nil
 }
 
+// Info returns the module path and absolute directory of the main module.
+func (m Module) Info() (Info, error) {
+	return ReadInfo(m)
+}
+
+// ReadInfo is like Module.Info, but runs `go list` with e.
+func ReadInfo(e Executor) (Info, error) {
+	var buf bytes.Buffer
+	cmd := exec.Command("go", "list", "-m", "-json")
+	cmd.Stdout = &buf
+
+	err := e.Execute(cmd)
+	if err != nil {
+		return Info{}, err
+	}
+
+	var info Info
+	if err := json.Unmarshal(buf.Bytes(), &info); err != nil {
+		return Info{}, fmt.Errorf("invalid module info: %w", err)
+	}
+
+	return info, nil
+}
+
+// Clone copies the module directory (excluding the .git directory) to dir,
+// returning a Module for the copy.
+func (m Module) Clone(dir string) (Module, error) {
+	err := filepath.Walk(m.Path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(m.Path, path)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dir, rel)
+
+		switch {
+		case info.IsDir():
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(dst, info.Mode().Perm()|0700)
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, dst)
+		case info.Mode().IsRegular():
+			return CopyFile(path, dst, info.Mode().Perm())
+		}
+		return nil
+	})
+	if err != nil {
+		return Module{}, fmt.Errorf("failed to copy module: %w", err)
+	}
+	return Module{Path: dir}, nil
+}
+
+// CopyFile copies the contents of src to dst, creating dst with perm. An
+// existing dst is replaced, even if it's read-only (as files in the module
+// cache are).
+func CopyFile(src, dst string, perm os.FileMode) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	os.Remove(dst)
+	dstFile, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer dstFile.Close()
+
+	if _, err := io.Copy(dstFile, srcFile); err != nil {
+		return err
+	}
+	return dstFile.Close()
+}
+
 func (m Module) Execute(cmd *exec.Cmd) error {
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 	cmd.Dir = m.Path
 
 	if err := cmd.Run(); err != nil {
-		return ExecutionError{
-			Err:    err,
-			Stderr: stderr.String(),
+		execErr := ExecutionError{
+			Err:      err,
+			Stderr:   stderr.String(),
+			ExitCode: -1,
 		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			execErr.ExitCode = exitErr.ExitCode()
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+				execErr.Signal = status.Signal()
+			}
+		}
+		return execErr
 	}
 
 	return nil
@@ -76,6 +225,12 @@ func (m Module) Execute(cmd *exec.Cmd) error {
 type ExecutionError struct {
 	Err    error
 	Stderr string
+
+	// ExitCode is the exit code of the process, or -1 if it didn't exit
+	// normally (e.g. it failed to start or was killed by a signal).
+	ExitCode int
+	// Signal is the signal that killed the process, if any.
+	Signal syscall.Signal
 }
 
 func (e ExecutionError) Unwrap() error {
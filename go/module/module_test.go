@@ -0,0 +1,48 @@
+package module
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVersions(t *testing.T) {
+	versions, err := ParseVersions(strings.NewReader(`{"Path": "golang.org/x/mod", "Version": "v0.40.0"}
+{"Path": "example.com/a", "Version": "v1.1.0", "Error": "module lookup disabled by GOPROXY=off"}
+{"Path": "example.com/a", "Version": "v1.0.0"}
+`))
+	require.NoError(t, err)
+	require.Equal(t, []Version{
+		{Path: "example.com/a", Version: "v1.0.0"},
+		{Path: "example.com/a", Version: "v1.1.0", Error: "module lookup disabled by GOPROXY=off"},
+		{Path: "golang.org/x/mod", Version: "v0.40.0"},
+	}, versions)
+	require.Equal(t, "example.com/a@v1.0.0", versions[0].String())
+
+	_, err = ParseVersions(strings.NewReader(`{"Path": `))
+	require.Error(t, err)
+}
+
+func TestQuote(t *testing.T) {
+	for _, tt := range []struct {
+		arg    string
+		quoted string
+		err    string
+	}{
+		{arg: "/usr/bin/go", quoted: "/usr/bin/go"},
+		{arg: "", quoted: "''"},
+		{arg: "/my dir/go", quoted: "'/my dir/go'"},
+		{arg: "it's", quoted: `"it's"`},
+		{arg: `say "hi"`, quoted: `'say "hi"'`},
+		{arg: `it's "hi"`, err: `it's "hi" cannot be quoted for the go command, as it contains both single and double quotes`},
+	} {
+		quoted, err := Quote(tt.arg)
+		if tt.err != "" {
+			require.EqualError(t, err, tt.err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, tt.quoted, quoted)
+	}
+}
@@ -0,0 +1,23 @@
+package module
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Quote quotes an argument containing spaces or quotes, so that the go
+// command splits it back out of a list of arguments, such as -ldflags or
+// GOCACHEPROG. Arguments are quoted with either single or double quotes, with
+// no escaping, so an argument can't contain both.
+func Quote(arg string) (string, error) {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n\r'\"") {
+		return arg, nil
+	}
+	if !strings.Contains(arg, "'") {
+		return "'" + arg + "'", nil
+	}
+	if !strings.Contains(arg, `"`) {
+		return `"` + arg + `"`, nil
+	}
+	return "", fmt.Errorf("%s cannot be quoted for the go command, as it contains both single and double quotes", arg)
+}
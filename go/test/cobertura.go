@@ -0,0 +1,137 @@
+package test
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aoldershaw/prototype-experiments/go/module"
+)
+
+type coberturaCoverage struct {
+	XMLName         xml.Name           `xml:"coverage"`
+	LineRate        string             `xml:"line-rate,attr"`
+	BranchRate      string             `xml:"branch-rate,attr"`
+	LinesCovered    int                `xml:"lines-covered,attr"`
+	LinesValid      int                `xml:"lines-valid,attr"`
+	BranchesCovered int                `xml:"branches-covered,attr"`
+	BranchesValid   int                `xml:"branches-valid,attr"`
+	Complexity      string             `xml:"complexity,attr"`
+	Version         string             `xml:"version,attr"`
+	Timestamp       int64              `xml:"timestamp,attr"`
+	Sources         []string           `xml:"sources>source"`
+	Packages        []coberturaPackage `xml:"packages>package"`
+}
+
+type coberturaPackage struct {
+	Name       string           `xml:"name,attr"`
+	LineRate   string           `xml:"line-rate,attr"`
+	BranchRate string           `xml:"branch-rate,attr"`
+	Complexity string           `xml:"complexity,attr"`
+	Classes    []coberturaClass `xml:"classes>class"`
+}
+
+type coberturaClass struct {
+	Name       string          `xml:"name,attr"`
+	Filename   string          `xml:"filename,attr"`
+	LineRate   string          `xml:"line-rate,attr"`
+	BranchRate string          `xml:"branch-rate,attr"`
+	Complexity string          `xml:"complexity,attr"`
+	Methods    struct{}        `xml:"methods"`
+	Lines      []coberturaLine `xml:"lines>line"`
+}
+
+type coberturaLine struct {
+	Number int `xml:"number,attr"`
+	Hits   int `xml:"hits,attr"`
+}
+
+// writeCobertura converts the coverage profile to the Cobertura XML format.
+// Coverage is reported per line, with file names relative to the module
+// directory.
+func writeCobertura(dst string, profile Profile, info module.Info) error {
+	coverage := coberturaCoverage{
+		BranchRate: "0",
+		Complexity: "0",
+		Timestamp:  time.Now().UnixNano() / int64(time.Millisecond),
+		Sources:    []string{info.Dir},
+	}
+
+	packages := map[string]*coberturaPackage{}
+	var packageNames []string
+	linesCovered := map[string]int{}
+	linesValid := map[string]int{}
+	for _, file := range profile.Files() {
+		importPath := path.Dir(file)
+		pkg, ok := packages[importPath]
+		if !ok {
+			pkg = &coberturaPackage{
+				Name:       importPath,
+				BranchRate: "0",
+				Complexity: "0",
+			}
+			packages[importPath] = pkg
+			packageNames = append(packageNames, importPath)
+		}
+
+		class := coberturaClass{
+			Name:       path.Base(file),
+			Filename:   strings.TrimPrefix(file, info.Path+"/"),
+			BranchRate: "0",
+			Complexity: "0",
+		}
+		lines := profile.Lines(file)
+		var numbers []int
+		for number := range lines {
+			numbers = append(numbers, number)
+		}
+		sort.Ints(numbers)
+		covered := 0
+		for _, number := range numbers {
+			hits := lines[number]
+			if hits > 0 {
+				covered++
+			}
+			class.Lines = append(class.Lines, coberturaLine{Number: number, Hits: hits})
+		}
+		class.LineRate = lineRate(covered, len(numbers))
+		pkg.Classes = append(pkg.Classes, class)
+
+		linesCovered[importPath] += covered
+		linesValid[importPath] += len(numbers)
+		coverage.LinesCovered += covered
+		coverage.LinesValid += len(numbers)
+	}
+
+	sort.Strings(packageNames)
+	for _, name := range packageNames {
+		pkg := packages[name]
+		pkg.LineRate = lineRate(linesCovered[name], linesValid[name])
+		coverage.Packages = append(coverage.Packages, *pkg)
+	}
+	coverage.LineRate = lineRate(coverage.LinesCovered, coverage.LinesValid)
+
+	file, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create cobertura file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(xml.Header); err != nil {
+		return fmt.Errorf("failed to write cobertura file: %w", err)
+	}
+	encoder := xml.NewEncoder(file)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(coverage); err != nil {
+		return fmt.Errorf("failed to write cobertura file: %w", err)
+	}
+	return nil
+}
+
+func lineRate(covered, total int) string {
+	return fmt.Sprintf("%.4f", percent(covered, total)/100)
+}
@@ -0,0 +1,197 @@
+package test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Profile is a coverage profile, as written by `go test -coverprofile`.
+type Profile struct {
+	Mode   string
+	Blocks []ProfileBlock
+}
+
+type ProfileBlock struct {
+	File      string
+	StartLine int
+	StartCol  int
+	EndLine   int
+	EndCol    int
+	NumStmt   int
+	Count     int
+}
+
+type blockKey struct {
+	File      string
+	StartLine int
+	StartCol  int
+	EndLine   int
+	EndCol    int
+}
+
+// ParseProfile reads a coverage profile. The profile may be the concatenation
+// of several profiles (as is the case when running `go test -coverpkg` over
+// multiple packages), in which case blocks that are reported more than once
+// are merged together.
+func ParseProfile(r io.Reader) (Profile, error) {
+	var profile Profile
+	blocks := map[blockKey]int{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "mode: ") {
+			mode := strings.TrimPrefix(line, "mode: ")
+			if profile.Mode != "" && profile.Mode != mode {
+				return Profile{}, fmt.Errorf("cannot merge coverage profiles with modes %q and %q", profile.Mode, mode)
+			}
+			profile.Mode = mode
+			continue
+		}
+
+		var block ProfileBlock
+		sep := strings.LastIndex(line, ":")
+		if sep < 0 {
+			return Profile{}, fmt.Errorf("invalid coverage profile line: %s", line)
+		}
+		block.File = line[:sep]
+		_, err := fmt.Sscanf(line[sep+1:], "%d.%d,%d.%d %d %d",
+			&block.StartLine, &block.StartCol,
+			&block.EndLine, &block.EndCol,
+			&block.NumStmt, &block.Count,
+		)
+		if err != nil {
+			return Profile{}, fmt.Errorf("invalid coverage profile line: %s", line)
+		}
+
+		key := blockKey{
+			File:      block.File,
+			StartLine: block.StartLine,
+			StartCol:  block.StartCol,
+			EndLine:   block.EndLine,
+			EndCol:    block.EndCol,
+		}
+		i, ok := blocks[key]
+		if !ok {
+			blocks[key] = len(profile.Blocks)
+			profile.Blocks = append(profile.Blocks, block)
+			continue
+		}
+		if profile.Mode == "set" {
+			if block.Count > profile.Blocks[i].Count {
+				profile.Blocks[i].Count = block.Count
+			}
+		} else {
+			profile.Blocks[i].Count += block.Count
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Profile{}, fmt.Errorf("read coverage profile: %w", err)
+	}
+
+	sort.SliceStable(profile.Blocks, func(i, j int) bool {
+		bi, bj := profile.Blocks[i], profile.Blocks[j]
+		if bi.File != bj.File {
+			return bi.File < bj.File
+		}
+		if bi.StartLine != bj.StartLine {
+			return bi.StartLine < bj.StartLine
+		}
+		return bi.StartCol < bj.StartCol
+	})
+
+	return profile, nil
+}
+
+func (p Profile) Write(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "mode: %s\n", p.Mode); err != nil {
+		return err
+	}
+	for _, b := range p.Blocks {
+		_, err := fmt.Fprintf(w, "%s:%d.%d,%d.%d %d %d\n", b.File, b.StartLine, b.StartCol, b.EndLine, b.EndCol, b.NumStmt, b.Count)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Files returns the names of the files in the profile, in sorted order.
+func (p Profile) Files() []string {
+	var files []string
+	for _, b := range p.Blocks {
+		if len(files) == 0 || files[len(files)-1] != b.File {
+			files = append(files, b.File)
+		}
+	}
+	return files
+}
+
+// Lines returns the number of hits for each line containing statements in the
+// given file.
+func (p Profile) Lines(file string) map[int]int {
+	lines := map[int]int{}
+	for _, b := range p.Blocks {
+		if b.File != file || b.NumStmt == 0 {
+			continue
+		}
+		for line := b.StartLine; line <= b.EndLine; line++ {
+			if hits, ok := lines[line]; !ok || b.Count > hits {
+				lines[line] = b.Count
+			}
+		}
+	}
+	return lines
+}
+
+// Coverage returns the number of covered and total statements, optionally
+// limited to the files for which include returns true.
+func (p Profile) Coverage(include func(file string) bool) (covered, total int) {
+	for _, b := range p.Blocks {
+		if include != nil && !include(b.File) {
+			continue
+		}
+		total += b.NumStmt
+		if b.Count > 0 {
+			covered += b.NumStmt
+		}
+	}
+	return covered, total
+}
+
+func readProfile(path string) (Profile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Profile{}, fmt.Errorf("failed to open coverage profile: %w", err)
+	}
+	defer file.Close()
+
+	return ParseProfile(file)
+}
+
+func writeProfile(path string, profile Profile) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create coverage profile: %w", err)
+	}
+	defer file.Close()
+
+	if err := profile.Write(file); err != nil {
+		return fmt.Errorf("failed to write coverage profile: %w", err)
+	}
+	return nil
+}
+
+func percent(covered, total int) float64 {
+	if total == 0 {
+		return 100
+	}
+	return 100 * float64(covered) / float64(total)
+}
@@ -0,0 +1,156 @@
+package test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aoldershaw/prototype-experiments/go/module"
+	"github.com/stretchr/testify/require"
+)
+
+const rawProfile = `mode: set
+example.com/gt/a/a.go:3.14,5.2 1 1
+example.com/gt/a/a.go:7.14,9.2 1 0
+example.com/gt/b/b.go:3.14,6.2 2 0
+mode: set
+example.com/gt/a/a.go:3.14,5.2 1 0
+example.com/gt/a/a.go:7.14,9.2 1 1
+example.com/gt/b/b.go:3.14,6.2 2 0
+`
+
+func TestParseProfile(t *testing.T) {
+	profile, err := ParseProfile(strings.NewReader(rawProfile))
+	require.NoError(t, err)
+
+	var merged strings.Builder
+	require.NoError(t, profile.Write(&merged))
+	require.Equal(t, `mode: set
+example.com/gt/a/a.go:3.14,5.2 1 1
+example.com/gt/a/a.go:7.14,9.2 1 1
+example.com/gt/b/b.go:3.14,6.2 2 0
+`, merged.String())
+
+	covered, total := profile.Coverage(nil)
+	require.Equal(t, 2, covered)
+	require.Equal(t, 4, total)
+
+	require.Equal(t, map[int]int{3: 1, 4: 1, 5: 1, 7: 1, 8: 1, 9: 1}, profile.Lines("example.com/gt/a/a.go"))
+}
+
+func TestParseProfileCountMode(t *testing.T) {
+	profile, err := ParseProfile(strings.NewReader(strings.ReplaceAll(rawProfile, "mode: set", "mode: count")))
+	require.NoError(t, err)
+	require.Equal(t, 1, profile.Blocks[0].Count)
+	require.Equal(t, 1, profile.Blocks[1].Count)
+
+	_, err = ParseProfile(strings.NewReader("mode: set\nmode: count\n"))
+	require.Error(t, err)
+}
+
+func TestWriteCobertura(t *testing.T) {
+	profile, err := ParseProfile(strings.NewReader(rawProfile))
+	require.NoError(t, err)
+
+	dst := filepath.Join(t.TempDir(), "cobertura.xml")
+	err = writeCobertura(dst, profile, module.Info{Path: "example.com/gt", Dir: "/src/gt"})
+	require.NoError(t, err)
+
+	contents, err := ioutil.ReadFile(dst)
+	require.NoError(t, err)
+	require.Contains(t, string(contents), `<source>/src/gt</source>`)
+	require.Contains(t, string(contents), `<package name="example.com/gt/a" line-rate="1.0000"`)
+	require.Contains(t, string(contents), `<class name="b.go" filename="b/b.go" line-rate="0.0000"`)
+	require.Contains(t, string(contents), `<line number="4" hits="0"></line>`)
+}
+
+func TestParseDiff(t *testing.T) {
+	changed, err := parseDiff(strings.NewReader(`diff --git a/a/a.go b/a/a.go
+index 1111111..2222222 100644
+--- a/a/a.go
++++ b/a/a.go
+@@ -3,0 +4,2 @@ func A() {
++	x := 1
++	_ = x
+@@ -10 +12 @@ func B() {
+-	return 1
++	return 2
+diff --git a/old.go b/old.go
+deleted file mode 100644
+--- a/old.go
++++ /dev/null
+@@ -1,3 +0,0 @@
+-package gt
+-
+-var x = 1
+`))
+	require.NoError(t, err)
+	require.Equal(t, map[string]map[int]bool{
+		"a/a.go": {4: true, 5: true, 12: true},
+	}, changed)
+}
+
+func TestGate(t *testing.T) {
+	profile, err := ParseProfile(strings.NewReader(`mode: set
+example.com/gt/a/a.go:3.14,5.2 1 1
+example.com/gt/a/a.go:7.14,9.2 1 0
+example.com/gt/b/b.go:3.14,6.2 2 0
+`))
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		desc   string
+		gate   Gate
+		result GateResult
+	}{
+		{
+			desc:   "no thresholds",
+			gate:   Gate{},
+			result: GateResult{Coverage: 25, Uncovered: map[string][]int{}},
+		},
+		{
+			desc: "thresholds",
+			gate: Gate{
+				Threshold: 30,
+				PackageThresholds: map[string]float64{
+					"example.com/gt/a": 50,
+					"example.com/gt/b": 10,
+				},
+			},
+			result: GateResult{
+				Coverage: 25,
+				Violations: []string{
+					"total coverage 25.0% is below threshold 30.0%",
+					"example.com/gt/b: coverage 0.0% is below threshold 10.0%",
+				},
+				Uncovered: map[string][]int{},
+			},
+		},
+		{
+			desc: "diff coverage",
+			gate: Gate{
+				Threshold: 80,
+				ChangedLines: map[string]map[int]bool{
+					"example.com/gt/a/a.go": {4: true, 8: true, 9: true, 20: true},
+				},
+			},
+			result: GateResult{
+				Coverage: 100.0 / 3,
+				Violations: []string{
+					"total diff coverage 33.3% is below threshold 80.0%",
+				},
+				Uncovered: map[string][]int{
+					"example.com/gt/a/a.go": {8, 9},
+				},
+			},
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			result := tt.gate.Check(profile)
+			require.Equal(t, tt.result, result)
+		})
+	}
+
+	require.Equal(t, "3-5, 9, 12-13", lineRanges([]int{3, 4, 5, 9, 12, 13}))
+}
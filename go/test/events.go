@@ -0,0 +1,197 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Event is a single event emitted by `go test -json`. See `go doc
+// test2json` for details.
+type Event struct {
+	Time        time.Time
+	Action      string
+	Package     string
+	Test        string
+	Elapsed     float64
+	Output      string
+	ImportPath  string
+	FailedBuild string
+}
+
+type Report struct {
+	Packages []*PackageResult `json:"packages"`
+}
+
+type PackageResult struct {
+	ImportPath  string        `json:"import_path"`
+	Status      string        `json:"status"`
+	Elapsed     float64       `json:"elapsed"`
+	FailedBuild bool          `json:"failed_build,omitempty"`
+	Output      string        `json:"output,omitempty"`
+	Tests       []*TestResult `json:"tests"`
+}
+
+type TestResult struct {
+	Name    string  `json:"name"`
+	Status  string  `json:"status"`
+	Elapsed float64 `json:"elapsed"`
+	Output  string  `json:"output,omitempty"`
+}
+
+type Totals struct {
+	Packages int `json:"packages"`
+	Tests    int `json:"tests"`
+	Passed   int `json:"passed"`
+	Failed   int `json:"failed"`
+	Skipped  int `json:"skipped"`
+}
+
+// Failure is a failing test, or a failing package with no failing tests (e.g.
+// due to a build failure or a panic in TestMain).
+type Failure struct {
+	Package string
+	Test    string
+	Output  string
+}
+
+// ParseEvents reads the event stream from `go test -json` and aggregates it
+// into per-package and per-test results.
+func ParseEvents(r io.Reader) (Report, error) {
+	var report Report
+	packages := map[string]*PackageResult{}
+	tests := map[string]map[string]*TestResult{}
+	buildOutput := map[string]string{}
+
+	pkgResult := func(importPath string) *PackageResult {
+		pkg, ok := packages[importPath]
+		if !ok {
+			pkg = &PackageResult{ImportPath: importPath}
+			packages[importPath] = pkg
+			tests[importPath] = map[string]*TestResult{}
+			report.Packages = append(report.Packages, pkg)
+		}
+		return pkg
+	}
+	testResult := func(pkg *PackageResult, name string) *TestResult {
+		test, ok := tests[pkg.ImportPath][name]
+		if !ok {
+			test = &TestResult{Name: name}
+			tests[pkg.ImportPath][name] = test
+			pkg.Tests = append(pkg.Tests, test)
+		}
+		return test
+	}
+
+	decoder := json.NewDecoder(r)
+	for {
+		var event Event
+		err := decoder.Decode(&event)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Report{}, fmt.Errorf("decode test event: %w", err)
+		}
+
+		switch event.Action {
+		case "build-output":
+			buildOutput[event.ImportPath] += event.Output
+			continue
+		case "build-fail":
+			continue
+		}
+		if event.Package == "" {
+			continue
+		}
+
+		pkg := pkgResult(event.Package)
+		if event.Test == "" {
+			switch event.Action {
+			case "output":
+				pkg.Output += event.Output
+			case "pass", "fail", "skip":
+				pkg.Status = event.Action
+				pkg.Elapsed = event.Elapsed
+				if event.FailedBuild != "" {
+					pkg.FailedBuild = true
+					pkg.Output = buildOutput[event.FailedBuild] + pkg.Output
+				}
+			}
+			continue
+		}
+
+		test := testResult(pkg, event.Test)
+		switch event.Action {
+		case "run":
+			test.Status = "run"
+		case "output":
+			test.Output += event.Output
+		case "pass", "fail", "skip":
+			test.Status = event.Action
+			test.Elapsed = event.Elapsed
+		}
+	}
+
+	for _, pkg := range report.Packages {
+		if pkg.Status == "" {
+			// the package never reported a result, e.g. when the test binary
+			// was killed by a timeout
+			pkg.Status = "fail"
+		}
+		for _, test := range pkg.Tests {
+			if test.Status == "run" {
+				// the test started but never finished
+				test.Status = "fail"
+			}
+		}
+	}
+
+	return report, nil
+}
+
+func (r Report) Totals() Totals {
+	totals := Totals{Packages: len(r.Packages)}
+	for _, pkg := range r.Packages {
+		for _, test := range pkg.Tests {
+			totals.Tests++
+			switch test.Status {
+			case "pass":
+				totals.Passed++
+			case "fail":
+				totals.Failed++
+			case "skip":
+				totals.Skipped++
+			}
+		}
+	}
+	return totals
+}
+
+func (r Report) Failures() []Failure {
+	var failures []Failure
+	for _, pkg := range r.Packages {
+		if pkg.Status != "fail" {
+			continue
+		}
+		anyTestFailed := false
+		for _, test := range pkg.Tests {
+			if test.Status == "fail" {
+				anyTestFailed = true
+				failures = append(failures, Failure{
+					Package: pkg.ImportPath,
+					Test:    test.Name,
+					Output:  test.Output,
+				})
+			}
+		}
+		if !anyTestFailed {
+			failures = append(failures, Failure{
+				Package: pkg.ImportPath,
+				Output:  pkg.Output,
+			})
+		}
+	}
+	return failures
+}
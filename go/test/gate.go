@@ -0,0 +1,184 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Gate fails a test run when coverage drops below the configured thresholds.
+type Gate struct {
+	Threshold         float64
+	PackageThresholds map[string]float64
+
+	// ChangedLines, if non-nil, restricts the gate to only the given lines
+	// (keyed by the file name as it appears in the coverage profile). In this
+	// case, coverage is measured in lines rather than statements.
+	ChangedLines map[string]map[int]bool
+}
+
+type GateResult struct {
+	Coverage   float64
+	Violations []string
+
+	// Uncovered lists the changed lines that were not covered, by file. Only
+	// populated when the gate is restricted to ChangedLines.
+	Uncovered map[string][]int
+}
+
+func (r GateResult) Failed() bool {
+	return len(r.Violations) > 0
+}
+
+func (g Gate) Check(profile Profile) GateResult {
+	result := GateResult{Uncovered: map[string][]int{}}
+
+	var coverage func(include func(file string) bool) (int, int)
+	if g.ChangedLines == nil {
+		coverage = profile.Coverage
+	} else {
+		for _, file := range profile.Files() {
+			changed := g.ChangedLines[file]
+			for line, hits := range profile.Lines(file) {
+				if changed[line] && hits == 0 {
+					result.Uncovered[file] = append(result.Uncovered[file], line)
+				}
+			}
+			sort.Ints(result.Uncovered[file])
+		}
+		coverage = func(include func(file string) bool) (covered, total int) {
+			for _, file := range profile.Files() {
+				if include != nil && !include(file) {
+					continue
+				}
+				changed := g.ChangedLines[file]
+				for line, hits := range profile.Lines(file) {
+					if !changed[line] {
+						continue
+					}
+					total++
+					if hits > 0 {
+						covered++
+					}
+				}
+			}
+			return covered, total
+		}
+	}
+
+	kind := "coverage"
+	if g.ChangedLines != nil {
+		kind = "diff coverage"
+	}
+
+	result.Coverage = percent(coverage(nil))
+	if result.Coverage < g.Threshold {
+		result.Violations = append(result.Violations,
+			fmt.Sprintf("total %s %.1f%% is below threshold %.1f%%", kind, result.Coverage, g.Threshold),
+		)
+	}
+
+	var packages []string
+	for pkg := range g.PackageThresholds {
+		packages = append(packages, pkg)
+	}
+	sort.Strings(packages)
+	for _, pkg := range packages {
+		threshold := g.PackageThresholds[pkg]
+		pkgCoverage := percent(coverage(func(file string) bool {
+			return path.Dir(file) == pkg
+		}))
+		if pkgCoverage < threshold {
+			result.Violations = append(result.Violations,
+				fmt.Sprintf("%s: %s %.1f%% is below threshold %.1f%%", pkg, kind, pkgCoverage, threshold),
+			)
+		}
+	}
+
+	return result
+}
+
+// changedLines returns the lines added or modified since the given git ref,
+// keyed by the file name as it would appear in a coverage profile (i.e.
+// prefixed by the module path).
+func changedLines(mod Module, base, modulePath string) (map[string]map[int]bool, error) {
+	var stdout bytes.Buffer
+	cmd := exec.Command("git", "diff", "--no-color", "--no-ext-diff", "--unified=0", "--relative", base, "--", ".")
+	cmd.Stdout = &stdout
+	if err := mod.Execute(cmd); err != nil {
+		return nil, fmt.Errorf("failed to diff against %s: %w", base, err)
+	}
+
+	changed, err := parseDiff(&stdout)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make(map[string]map[int]bool, len(changed))
+	for file, fileLines := range changed {
+		lines[modulePath+"/"+file] = fileLines
+	}
+	return lines, nil
+}
+
+// parseDiff returns the added lines in each file of a unified diff.
+func parseDiff(r io.Reader) (map[string]map[int]bool, error) {
+	changed := map[string]map[int]bool{}
+	var file string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "+++ "):
+			file = ""
+			name := strings.TrimPrefix(line, "+++ ")
+			if name != "/dev/null" {
+				file = strings.TrimPrefix(name, "b/")
+			}
+		case strings.HasPrefix(line, "@@ ") && file != "":
+			// @@ -start[,count] +start[,count] @@
+			fields := strings.Fields(line)
+			if len(fields) < 3 || !strings.HasPrefix(fields[2], "+") {
+				return nil, fmt.Errorf("invalid hunk header: %s", line)
+			}
+			start, count, err := parseHunkRange(strings.TrimPrefix(fields[2], "+"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid hunk header: %s", line)
+			}
+			if changed[file] == nil {
+				changed[file] = map[int]bool{}
+			}
+			for i := start; i < start+count; i++ {
+				changed[file][i] = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read diff: %w", err)
+	}
+
+	return changed, nil
+}
+
+func parseHunkRange(r string) (start, count int, err error) {
+	parts := strings.SplitN(r, ",", 2)
+	start, err = strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	count = 1
+	if len(parts) == 2 {
+		count, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return start, count, nil
+}
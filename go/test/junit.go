@@ -0,0 +1,109 @@
+package test
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+	SystemOut string          `xml:"system-out,omitempty"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message  string `xml:"message,attr"`
+	Contents string `xml:",chardata"`
+}
+
+func writeJUnit(path string, report Report) error {
+	var suites junitTestSuites
+	for _, pkg := range report.Packages {
+		suite := junitTestSuite{
+			Name:      pkg.ImportPath,
+			Time:      junitTime(pkg.Elapsed),
+			SystemOut: pkg.Output,
+		}
+		anyTestFailed := false
+		for _, test := range pkg.Tests {
+			testCase := junitTestCase{
+				ClassName: pkg.ImportPath,
+				Name:      test.Name,
+				Time:      junitTime(test.Elapsed),
+			}
+			switch test.Status {
+			case "fail":
+				anyTestFailed = true
+				suite.Failures++
+				testCase.Failure = &junitMessage{Message: "Failed", Contents: test.Output}
+			case "skip":
+				suite.Skipped++
+				testCase.Skipped = &junitMessage{Message: "Skipped", Contents: test.Output}
+			}
+			suite.Tests++
+			suite.TestCases = append(suite.TestCases, testCase)
+		}
+		if pkg.Status == "fail" && !anyTestFailed {
+			// report package-level failures as a test case, since most JUnit
+			// consumers ignore failures on the suite itself
+			name := "[package failed]"
+			if pkg.FailedBuild {
+				name = "[build failed]"
+			}
+			suite.Tests++
+			suite.Failures++
+			suite.TestCases = append(suite.TestCases, junitTestCase{
+				ClassName: pkg.ImportPath,
+				Name:      name,
+				Time:      junitTime(pkg.Elapsed),
+				Failure:   &junitMessage{Message: "Failed", Contents: pkg.Output},
+			})
+		}
+
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Skipped += suite.Skipped
+		suites.Suites = append(suites.Suites, suite)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create junit file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(xml.Header); err != nil {
+		return fmt.Errorf("failed to write junit file: %w", err)
+	}
+	encoder := xml.NewEncoder(file)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suites); err != nil {
+		return fmt.Errorf("failed to write junit file: %w", err)
+	}
+	return nil
+}
+
+func junitTime(seconds float64) string {
+	return fmt.Sprintf("%.3f", seconds)
+}
@@ -0,0 +1,326 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/aoldershaw/prototype-experiments/go/build"
+	"github.com/aoldershaw/prototype-experiments/go/module"
+	"github.com/aoldershaw/prototype-sdk-go"
+)
+
+type Params struct {
+	Package build.OneOrMany `json:"package"`
+
+	Run     string   `json:"run"`
+	Tags    []string `json:"tags"`
+	ModMode string   `json:"mod"`
+	Race    bool     `json:"race"`
+	Cgo     bool     `json:"cgo"`
+
+	Coverage      bool            `json:"coverage"`
+	CoverMode     string          `json:"covermode"`
+	CoverPackages build.OneOrMany `json:"coverpkg"`
+
+	CoverageThreshold         float64            `json:"coverage_threshold"`
+	PackageCoverageThresholds map[string]float64 `json:"package_coverage_thresholds"`
+	CoverageDiffBase          string             `json:"coverage_diff_base"`
+
+	build.Caches
+}
+
+type Module interface {
+	Execute(*exec.Cmd) error
+	ResolvePackages(packages ...string) ([]module.Package, error)
+	Info() (module.Info, error)
+}
+
+func Test(mod Module, params Params) ([]prototype.MessageResponse, error) {
+	junitDir := "./junit"
+	err := os.MkdirAll(junitDir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create junit directory: %w", err)
+	}
+
+	summaryDir := "./summary"
+	err = os.MkdirAll(summaryDir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create summary directory: %w", err)
+	}
+
+	gopathDir, gocacheDir, err := params.Caches.Prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	if params.CoverageThreshold > 0 || len(params.PackageCoverageThresholds) > 0 || params.CoverageDiffBase != "" {
+		params.Coverage = true
+	}
+
+	var coverProfile string
+	if params.Coverage {
+		tmpDir, err := os.MkdirTemp("", "coverage")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temp directory: %w", err)
+		}
+		defer os.RemoveAll(tmpDir)
+
+		coverProfile = filepath.Join(tmpDir, "coverage.out")
+	}
+
+	report, err := test(mod, params, gopathDir, gocacheDir, coverProfile)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeJUnit(filepath.Join(junitDir, "junit.xml"), report); err != nil {
+		return nil, err
+	}
+	if err := writeSummary(filepath.Join(summaryDir, "summary.json"), report); err != nil {
+		return nil, err
+	}
+
+	PrintReport(report)
+
+	object := map[string]interface{}{
+		"junit":   prototype.Artifact(junitDir),
+		"summary": prototype.Artifact(summaryDir),
+		"gopath":  prototype.Artifact(gopathDir),
+		"gocache": prototype.Artifact(gocacheDir),
+	}
+
+	if params.Coverage && len(report.Failures()) > 0 {
+		// go test doesn't write a profile if e.g. a package fails to build,
+		// in which case the test failures are reported without coverage
+		if _, err := os.Stat(coverProfile); errors.Is(err, fs.ErrNotExist) {
+			fmt.Println("\nno coverage profile was written, skipping coverage")
+			params.Coverage = false
+		}
+	}
+
+	var gateResult GateResult
+	if params.Coverage {
+		coverageDir := "./coverage"
+		err := os.MkdirAll(coverageDir, 0755)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create coverage directory: %w", err)
+		}
+
+		coberturaDir := "./cobertura"
+		err = os.MkdirAll(coberturaDir, 0755)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cobertura directory: %w", err)
+		}
+
+		profile, err := coverage(mod, params, coverProfile, coverageDir, coberturaDir, gopathDir, gocacheDir)
+		if err != nil {
+			return nil, err
+		}
+
+		covered, total := profile.Coverage(nil)
+		fmt.Printf("\ncoverage: %.1f%% of statements\n", percent(covered, total))
+
+		gateResult, err = checkCoverage(mod, params, profile)
+		if err != nil {
+			return nil, err
+		}
+		PrintGateResult(gateResult)
+
+		object["coverage"] = prototype.Artifact(coverageDir)
+		object["cobertura"] = prototype.Artifact(coberturaDir)
+	}
+
+	responses := []prototype.MessageResponse{{
+		Object: object,
+	}}
+
+	// the reports are most useful when tests fail, so they're published
+	// either way
+	if failures := report.Failures(); len(failures) > 0 {
+		return module.Fail(responses, fmt.Errorf("%d test(s) failed", len(failures)))
+	}
+	if gateResult.Failed() {
+		return module.Fail(responses, fmt.Errorf("coverage gate failed"))
+	}
+
+	return responses, nil
+}
+
+func test(mod Module, params Params, gopathDir, gocacheDir, coverProfile string) (Report, error) {
+	// get absolute paths since go command runs in a different directory
+	gopathDir, err := filepath.Abs(gopathDir)
+	if err != nil {
+		return Report{}, fmt.Errorf("get absolute path: %w", err)
+	}
+	gocacheDir, err = filepath.Abs(gocacheDir)
+	if err != nil {
+		return Report{}, fmt.Errorf("get absolute path: %w", err)
+	}
+
+	if len(params.Package) == 0 {
+		params.Package = build.OneOrMany{"./..."}
+	}
+
+	cmd := exec.Command("go", "test", "-json")
+	if params.ModMode != "" {
+		cmd.Args = append(cmd.Args, "-mod", params.ModMode)
+	}
+	if params.Race {
+		cmd.Args = append(cmd.Args, "-race")
+	}
+	if len(params.Tags) > 0 {
+		cmd.Args = append(cmd.Args, "-tags", strings.Join(params.Tags, ","))
+	}
+	if params.Run != "" {
+		cmd.Args = append(cmd.Args, "-run", params.Run)
+	}
+	if coverProfile != "" {
+		coverPackages := params.CoverPackages
+		if len(coverPackages) == 0 {
+			coverPackages = params.Package
+		}
+		packages, err := mod.ResolvePackages(coverPackages...)
+		if err != nil {
+			return Report{}, fmt.Errorf("failed to locate packages: %w", err)
+		}
+		importPaths := make([]string, len(packages))
+		for i, pkg := range packages {
+			importPaths[i] = pkg.ImportPath
+		}
+
+		coverMode := params.CoverMode
+		if coverMode == "" {
+			coverMode = "set"
+			if params.Race {
+				coverMode = "atomic"
+			}
+		}
+		cmd.Args = append(cmd.Args,
+			"-covermode", coverMode,
+			"-coverpkg", strings.Join(importPaths, ","),
+			"-coverprofile", coverProfile,
+		)
+	}
+	cmd.Args = append(cmd.Args, params.Package...)
+	cmd.Env = env(params, gopathDir, gocacheDir)
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	runErr := mod.Execute(cmd)
+
+	report, err := ParseEvents(&stdout)
+	if err != nil {
+		return Report{}, fmt.Errorf("failed to parse test output: %w", err)
+	}
+
+	// go test exits non-zero when tests fail, which is reported separately.
+	// Any other error (e.g. invalid flags or package patterns) won't show up
+	// in the report, so return it as-is.
+	if runErr != nil && len(report.Failures()) == 0 {
+		return Report{}, runErr
+	}
+
+	return report, nil
+}
+
+// coverage merges the raw coverage profile written by go test and renders it
+// as HTML and Cobertura XML.
+func coverage(mod Module, params Params, rawProfile, coverageDir, coberturaDir, gopathDir, gocacheDir string) (Profile, error) {
+	// get absolute paths since go command runs in a different directory
+	coverageDir, err := filepath.Abs(coverageDir)
+	if err != nil {
+		return Profile{}, fmt.Errorf("get absolute path: %w", err)
+	}
+	gopathDir, err = filepath.Abs(gopathDir)
+	if err != nil {
+		return Profile{}, fmt.Errorf("get absolute path: %w", err)
+	}
+	gocacheDir, err = filepath.Abs(gocacheDir)
+	if err != nil {
+		return Profile{}, fmt.Errorf("get absolute path: %w", err)
+	}
+
+	profile, err := readProfile(rawProfile)
+	if err != nil {
+		return Profile{}, err
+	}
+
+	profilePath := filepath.Join(coverageDir, "coverage.out")
+	if err := writeProfile(profilePath, profile); err != nil {
+		return Profile{}, err
+	}
+
+	cmd := exec.Command("go", "tool", "cover",
+		"-html", profilePath,
+		"-o", filepath.Join(coverageDir, "coverage.html"),
+	)
+	cmd.Env = env(params, gopathDir, gocacheDir)
+	if err := mod.Execute(cmd); err != nil {
+		return Profile{}, fmt.Errorf("failed to render coverage html: %w", err)
+	}
+
+	info, err := mod.Info()
+	if err != nil {
+		return Profile{}, fmt.Errorf("failed to get module info: %w", err)
+	}
+	if err := writeCobertura(filepath.Join(coberturaDir, "cobertura.xml"), profile, info); err != nil {
+		return Profile{}, err
+	}
+
+	return profile, nil
+}
+
+func checkCoverage(mod Module, params Params, profile Profile) (GateResult, error) {
+	gate := Gate{
+		Threshold:         params.CoverageThreshold,
+		PackageThresholds: params.PackageCoverageThresholds,
+	}
+	if params.CoverageDiffBase != "" {
+		info, err := mod.Info()
+		if err != nil {
+			return GateResult{}, fmt.Errorf("failed to get module info: %w", err)
+		}
+		gate.ChangedLines, err = changedLines(mod, params.CoverageDiffBase, info.Path)
+		if err != nil {
+			return GateResult{}, err
+		}
+	}
+	return gate.Check(profile), nil
+}
+
+func env(params Params, gopathDir, gocacheDir string) []string {
+	env := build.CacheEnv(gopathDir, gocacheDir)
+	if params.Cgo {
+		env = append(env, "CGO_ENABLED=1")
+	} else {
+		env = append(env, "CGO_ENABLED=0")
+	}
+	return env
+}
+
+func writeSummary(path string, report Report) error {
+	summary := struct {
+		Totals
+		Packages []*PackageResult `json:"packages"`
+	}{
+		Totals:   report.Totals(),
+		Packages: report.Packages,
+	}
+	payload, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal summary: %w", err)
+	}
+	if err := ioutil.WriteFile(path, payload, 0644); err != nil {
+		return fmt.Errorf("failed to write summary: %w", err)
+	}
+	return nil
+}
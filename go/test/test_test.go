@@ -0,0 +1,294 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/aoldershaw/prototype-experiments/go/build"
+	"github.com/aoldershaw/prototype-experiments/go/module"
+	"github.com/stretchr/testify/require"
+)
+
+type Cmd struct {
+	Args []string
+	Env  []string
+}
+
+type fakeModule struct {
+	packages map[string][]module.Package
+	stdout   string
+	err      error
+	cmds     []Cmd
+}
+
+func (m *fakeModule) ResolvePackages(packages ...string) ([]module.Package, error) {
+	var out []module.Package
+	for _, pkg := range packages {
+		cur, ok := m.packages[pkg]
+		if !ok {
+			panic(fmt.Sprintf("missing packages definition for %q", pkg))
+		}
+		out = append(out, cur...)
+	}
+	return out, nil
+}
+
+func (m *fakeModule) Info() (module.Info, error) {
+	return module.Info{Path: "example.com/gt", Dir: "/src/gt"}, nil
+}
+
+func (m *fakeModule) Execute(cmd *exec.Cmd) error {
+	m.cmds = append(m.cmds, Cmd{
+		Args: cmd.Args,
+		Env:  cmd.Env,
+	})
+	if m.stdout != "" {
+		f, err := os.Open(m.stdout)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err := io.Copy(cmd.Stdout, f); err != nil {
+			return err
+		}
+	}
+	return m.err
+}
+
+func TestParseEvents(t *testing.T) {
+	f, err := os.Open("testdata/events.json")
+	require.NoError(t, err)
+	defer f.Close()
+
+	report, err := ParseEvents(f)
+	require.NoError(t, err)
+
+	require.Equal(t, Report{
+		Packages: []*PackageResult{
+			{
+				ImportPath: "example.com/gt/a",
+				Status:     "fail",
+				Elapsed:    0.003,
+				Output:     "FAIL\texample.com/gt/a\t0.003s\n",
+				Tests: []*TestResult{
+					{
+						Name:    "TestOK",
+						Status:  "pass",
+						Elapsed: 0.01,
+						Output:  "=== RUN   TestOK\n--- PASS: TestOK (0.00s)\n",
+					},
+					{
+						Name:    "TestFail",
+						Status:  "fail",
+						Elapsed: 0.02,
+						Output:  "=== RUN   TestFail\n    a_test.go:4: boom\n--- FAIL: TestFail (0.00s)\n",
+					},
+					{
+						Name:   "TestSkip",
+						Status: "skip",
+						Output: "--- SKIP: TestSkip (0.00s)\n",
+					},
+				},
+			},
+			{
+				ImportPath:  "example.com/gt/b",
+				Status:      "fail",
+				FailedBuild: true,
+				Output:      "b/b.go:2:12: undefined: undefined\nFAIL\texample.com/gt/b [build failed]\n",
+			},
+			{
+				ImportPath: "example.com/gt/c",
+				Status:     "skip",
+				Output:     "?   \texample.com/gt/c\t[no test files]\n",
+			},
+		},
+	}, report)
+
+	require.Equal(t, Totals{Packages: 3, Tests: 3, Passed: 1, Failed: 1, Skipped: 1}, report.Totals())
+	require.Equal(t, []Failure{
+		{
+			Package: "example.com/gt/a",
+			Test:    "TestFail",
+			Output:  "=== RUN   TestFail\n    a_test.go:4: boom\n--- FAIL: TestFail (0.00s)\n",
+		},
+		{
+			Package: "example.com/gt/b",
+			Output:  "b/b.go:2:12: undefined: undefined\nFAIL\texample.com/gt/b [build failed]\n",
+		},
+	}, report.Failures())
+}
+
+func TestTest(t *testing.T) {
+	const gopathDir = "/gopath"
+	const gocacheDir = "/gocache"
+
+	env := func(cgo string) []string {
+		return []string{
+			"GOPATH=" + gopathDir,
+			"GOCACHE=" + gocacheDir,
+			"CGO_ENABLED=" + cgo,
+		}
+	}
+
+	for _, tt := range []struct {
+		desc         string
+		packages     map[string][]module.Package
+		params       Params
+		coverProfile string
+		stdout       string
+		execErr      error
+		commands     []Cmd
+		failures     int
+		err          string
+	}{
+		{
+			desc:   "defaults",
+			params: Params{},
+			commands: []Cmd{
+				{
+					Args: []string{"go", "test", "-json", "./..."},
+					Env:  env("0"),
+				},
+			},
+		},
+		{
+			desc: "flags",
+			params: Params{
+				Package: build.OneOrMany{"./foo/...", "./bar"},
+				Run:     "TestFoo",
+				Tags:    []string{"foo", "bar"},
+				ModMode: "vendor",
+				Race:    true,
+				Cgo:     true,
+			},
+			commands: []Cmd{
+				{
+					Args: []string{
+						"go", "test", "-json",
+						"-mod", "vendor",
+						"-race",
+						"-tags", "foo,bar",
+						"-run", "TestFoo",
+						"./foo/...", "./bar",
+					},
+					Env: env("1"),
+				},
+			},
+		},
+		{
+			desc: "coverage",
+			packages: map[string][]module.Package{
+				"./...": {
+					{Name: "main", ImportPath: "example.com/gt/cmd/gt"},
+					{Name: "a", ImportPath: "example.com/gt/a"},
+				},
+			},
+			params: Params{
+				Race: true,
+			},
+			coverProfile: "/tmp/coverage.out",
+			commands: []Cmd{
+				{
+					Args: []string{
+						"go", "test", "-json",
+						"-race",
+						"-covermode", "atomic",
+						"-coverpkg", "example.com/gt/cmd/gt,example.com/gt/a",
+						"-coverprofile", "/tmp/coverage.out",
+						"./...",
+					},
+					Env: env("0"),
+				},
+			},
+		},
+		{
+			desc: "coverage packages",
+			packages: map[string][]module.Package{
+				"./a/...": {
+					{Name: "a", ImportPath: "example.com/gt/a"},
+				},
+			},
+			params: Params{
+				CoverMode:     "count",
+				CoverPackages: build.OneOrMany{"./a/..."},
+			},
+			coverProfile: "/tmp/coverage.out",
+			commands: []Cmd{
+				{
+					Args: []string{
+						"go", "test", "-json",
+						"-covermode", "count",
+						"-coverpkg", "example.com/gt/a",
+						"-coverprofile", "/tmp/coverage.out",
+						"./...",
+					},
+					Env: env("0"),
+				},
+			},
+		},
+		{
+			desc:     "failing tests",
+			params:   Params{},
+			stdout:   "testdata/events.json",
+			execErr:  errors.New("exit status 1"),
+			failures: 2,
+			commands: []Cmd{
+				{
+					Args: []string{"go", "test", "-json", "./..."},
+					Env:  env("0"),
+				},
+			},
+		},
+		{
+			desc:    "command error",
+			params:  Params{},
+			execErr: errors.New("invalid flag"),
+			err:     "invalid flag",
+			commands: []Cmd{
+				{
+					Args: []string{"go", "test", "-json", "./..."},
+					Env:  env("0"),
+				},
+			},
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			mod := &fakeModule{packages: tt.packages, stdout: tt.stdout, err: tt.execErr}
+			report, err := test(mod, tt.params, gopathDir, gocacheDir, tt.coverProfile)
+			if tt.err != "" {
+				require.EqualError(t, err, tt.err)
+			} else {
+				require.NoError(t, err)
+				require.Len(t, report.Failures(), tt.failures)
+			}
+			require.Equal(t, tt.commands, mod.cmds)
+		})
+	}
+}
+
+func TestTestWithoutCoverageProfile(t *testing.T) {
+	events, err := filepath.Abs("testdata/events.json")
+	require.NoError(t, err)
+	t.Chdir(t.TempDir())
+
+	mod := &fakeModule{
+		packages: map[string][]module.Package{
+			"./...": {{Name: "b", ImportPath: "example.com/gt/b"}},
+		},
+		stdout: events,
+		err:    errors.New("exit status 1"),
+	}
+	responses, err := Test(mod, Params{Coverage: true})
+	require.EqualError(t, err, "2 test(s) failed")
+
+	require.Len(t, responses, 1)
+	require.Contains(t, responses[0].Object, "junit")
+	require.Contains(t, responses[0].Object, "summary")
+	require.NotContains(t, responses[0].Object, "coverage")
+	require.NotContains(t, responses[0].Object, "cobertura")
+}
@@ -0,0 +1,104 @@
+package test
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func PrintReport(report Report) {
+	for _, pkg := range report.Packages {
+		var statusText string
+		switch pkg.Status {
+		case "pass":
+			statusText = fmt.Sprintf("\x1b[32mpassed\x1b[0m  (%.3fs)", pkg.Elapsed)
+		case "fail":
+			if pkg.FailedBuild {
+				statusText = "\x1b[31mbuild failed\x1b[0m"
+			} else {
+				statusText = fmt.Sprintf("\x1b[31mfailed\x1b[0m  (%.3fs)", pkg.Elapsed)
+			}
+		case "skip":
+			statusText = "\x1b[33mskipped\x1b[0m  (no test files)"
+		}
+		fmt.Printf("--> %s ... %s\n", pkg.ImportPath, statusText)
+	}
+
+	totals := report.Totals()
+	fmt.Printf("\n%d passed, %d failed, %d skipped\n", totals.Passed, totals.Failed, totals.Skipped)
+
+	failures := report.Failures()
+	if len(failures) == 0 {
+		return
+	}
+
+	failuresWord := "failures"
+	if len(failures) == 1 {
+		failuresWord = "failure"
+	}
+	fmt.Printf("\n\x1b[1m%d %s occurred:\x1b[0m\n\n", len(failures), failuresWord)
+
+	for _, failure := range failures {
+		if failure.Test == "" {
+			fmt.Printf("--> %s:\n%s\n", failure.Package, indent(failure.Output))
+		} else {
+			fmt.Printf("--> %s: %s:\n%s\n", failure.Package, failure.Test, indent(failure.Output))
+		}
+	}
+}
+
+func indent(output string) string {
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = "    " + line
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func PrintGateResult(result GateResult) {
+	if !result.Failed() {
+		return
+	}
+
+	fmt.Printf("\n\x1b[1mcoverage gate failed:\x1b[0m\n\n")
+	for _, violation := range result.Violations {
+		fmt.Printf("--> %s\n", violation)
+	}
+
+	if len(result.Uncovered) == 0 {
+		return
+	}
+
+	var files []string
+	for file, lines := range result.Uncovered {
+		if len(lines) > 0 {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+
+	fmt.Printf("\n\x1b[1muncovered changed lines:\x1b[0m\n\n")
+	for _, file := range files {
+		fmt.Printf("--> %s: %s\n", file, lineRanges(result.Uncovered[file]))
+	}
+}
+
+// lineRanges formats a sorted list of line numbers as a list of ranges, e.g.
+// "3-5, 9, 12-13".
+func lineRanges(lines []int) string {
+	var ranges []string
+	for i := 0; i < len(lines); {
+		j := i
+		for j+1 < len(lines) && lines[j+1] == lines[j]+1 {
+			j++
+		}
+		if i == j {
+			ranges = append(ranges, strconv.Itoa(lines[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", lines[i], lines[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ", ")
+}
@@ -0,0 +1,182 @@
+package tidy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/aoldershaw/prototype-experiments/go/build"
+	"github.com/aoldershaw/prototype-experiments/go/diff"
+	"github.com/aoldershaw/prototype-experiments/go/module"
+	"github.com/aoldershaw/prototype-sdk-go"
+)
+
+// modFiles are the files that `go mod tidy` may modify.
+var modFiles = []string{"go.mod", "go.sum"}
+
+type Params struct {
+	build.Caches
+}
+
+type Module interface {
+	Execute(*exec.Cmd) error
+	Info() (module.Info, error)
+}
+
+type Result struct {
+	Changes []diff.Change
+	Patch   string
+
+	// VerifyError is the output of `go mod verify`, if it failed.
+	VerifyError string
+}
+
+func Tidy(mod module.Module, params Params) ([]prototype.MessageResponse, error) {
+	patchDir := "./patch"
+	err := os.MkdirAll(patchDir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create patch directory: %w", err)
+	}
+
+	gopathDir, gocacheDir, err := params.Caches.Prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	scratchDir, err := os.MkdirTemp("", "tidy")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(scratchDir)
+
+	scratch, err := mod.Clone(scratchDir)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := tidy(mod, scratch, gopathDir, gocacheDir)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ioutil.WriteFile(filepath.Join(patchDir, "tidy.patch"), []byte(result.Patch), 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write patch: %w", err)
+	}
+
+	PrintResult(result)
+
+	var errs []string
+	if len(result.Changes) > 0 {
+		errs = append(errs, "go mod tidy would modify "+changedFiles(result.Changes))
+	}
+	if result.VerifyError != "" {
+		errs = append(errs, "go mod verify failed")
+	}
+	responses := []prototype.MessageResponse{{
+		Object: map[string]interface{}{
+			"patch":   prototype.Artifact(patchDir),
+			"gopath":  prototype.Artifact(gopathDir),
+			"gocache": prototype.Artifact(gocacheDir),
+		},
+	}}
+
+	if len(errs) > 0 {
+		return module.Fail(responses, errors.New(strings.Join(errs, "; ")))
+	}
+
+	return responses, nil
+}
+
+// tidy runs `go mod tidy` in scratch (a copy of mod) and reports the
+// differences to go.mod and go.sum, after running `go mod verify` in mod.
+func tidy(mod, scratch Module, gopathDir, gocacheDir string) (Result, error) {
+	// get absolute paths since go command runs in a different directory
+	gopathDir, err := filepath.Abs(gopathDir)
+	if err != nil {
+		return Result{}, fmt.Errorf("get absolute path: %w", err)
+	}
+	gocacheDir, err = filepath.Abs(gocacheDir)
+	if err != nil {
+		return Result{}, fmt.Errorf("get absolute path: %w", err)
+	}
+
+	env := append(build.CacheEnv(gopathDir, gocacheDir), "GOFLAGS=-mod=mod")
+
+	modInfo, err := mod.Info()
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to get module info: %w", err)
+	}
+	before, err := readModFiles(modInfo.Dir)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var result Result
+
+	// verify the module cache before go mod tidy downloads anything into it,
+	// so that it checks the cache seeded from the gopath artifact. Nothing
+	// that's missing is downloaded either.
+	var stdout bytes.Buffer
+	cmd := exec.Command("go", "mod", "verify")
+	cmd.Env = append(env, "GOPROXY=off")
+	cmd.Stdout = &stdout
+	if err := mod.Execute(cmd); err != nil {
+		var execErr module.ExecutionError
+		if !errors.As(err, &execErr) {
+			return Result{}, fmt.Errorf("go mod verify failed: %w", err)
+		}
+		result.VerifyError = strings.TrimSpace(stdout.String() + execErr.Stderr)
+	}
+
+	cmd = exec.Command("go", "mod", "tidy")
+	cmd.Env = env
+	if err := scratch.Execute(cmd); err != nil {
+		return Result{}, fmt.Errorf("go mod tidy failed: %w", err)
+	}
+
+	scratchInfo, err := scratch.Info()
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to get module info: %w", err)
+	}
+	after, err := readModFiles(scratchInfo.Dir)
+	if err != nil {
+		return Result{}, err
+	}
+
+	prefix, err := diff.RepoPrefix(modInfo.Dir)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to locate repository root: %w", err)
+	}
+
+	result.Changes, result.Patch = diff.Compare(before, after, prefix)
+	return result, nil
+}
+
+func readModFiles(dir string) (diff.Snapshot, error) {
+	snapshot := diff.Snapshot{}
+	for _, name := range modFiles {
+		contents, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		snapshot[name] = contents
+	}
+	return snapshot, nil
+}
+
+func changedFiles(changes []diff.Change) string {
+	paths := make([]string, len(changes))
+	for i, change := range changes {
+		paths[i] = change.Path
+	}
+	return strings.Join(paths, " and ")
+}
@@ -0,0 +1,120 @@
+package tidy
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/aoldershaw/prototype-experiments/go/diff"
+	"github.com/aoldershaw/prototype-experiments/go/module"
+	"github.com/stretchr/testify/require"
+)
+
+type Cmd struct {
+	Args []string
+	Env  []string
+}
+
+type fakeModule struct {
+	dir     string
+	execute func(cmd *exec.Cmd) error
+	cmds    []Cmd
+}
+
+func (m *fakeModule) Info() (module.Info, error) {
+	return module.Info{Path: "example.com/gt", Dir: m.dir}, nil
+}
+
+func (m *fakeModule) Execute(cmd *exec.Cmd) error {
+	m.cmds = append(m.cmds, Cmd{
+		Args: cmd.Args,
+		Env:  cmd.Env,
+	})
+	if m.execute == nil {
+		return nil
+	}
+	return m.execute(cmd)
+}
+
+func TestTidy(t *testing.T) {
+	const gopathDir = "/gopath"
+	const gocacheDir = "/gocache"
+	const goMod = "module example.com/gt\n\ngo 1.16\n"
+
+	env := []string{
+		"GOPATH=" + gopathDir,
+		"GOCACHE=" + gocacheDir,
+		"GOFLAGS=-mod=mod",
+	}
+
+	for _, tt := range []struct {
+		desc        string
+		tidy        func(dir string) error
+		verify      func(cmd *exec.Cmd) error
+		changes     []diff.Change
+		verifyError string
+	}{
+		{
+			desc: "tidy",
+		},
+		{
+			desc: "untidy",
+			tidy: func(dir string) error {
+				err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte(goMod+"\nrequire example.com/dep v1.0.0\n"), 0644)
+				if err != nil {
+					return err
+				}
+				return ioutil.WriteFile(filepath.Join(dir, "go.sum"), []byte("example.com/dep v1.0.0 h1:abc=\n"), 0644)
+			},
+			changes: []diff.Change{
+				{Path: "go.mod", Status: "modified"},
+				{Path: "go.sum", Status: "added"},
+			},
+		},
+		{
+			desc: "verify fails",
+			verify: func(cmd *exec.Cmd) error {
+				cmd.Stdout.Write([]byte("example.com/dep v1.0.0: dir has been modified\n"))
+				return module.ExecutionError{Err: errors.New("exit status 1"), Stderr: ""}
+			},
+			verifyError: "example.com/dep v1.0.0: dir has been modified",
+		},
+	} {
+		t.Run(tt.desc, func(t *testing.T) {
+			// the module is in a subdirectory of the repository, which
+			// the paths in the patch are relative to
+			repo, scratchDir := t.TempDir(), t.TempDir()
+			require.NoError(t, os.MkdirAll(filepath.Join(repo, ".git"), 0755))
+			modDir := filepath.Join(repo, "go")
+			require.NoError(t, os.MkdirAll(modDir, 0755))
+			for _, dir := range []string{modDir, scratchDir} {
+				require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte(goMod), 0644))
+			}
+
+			mod := &fakeModule{dir: modDir, execute: tt.verify}
+			scratch := &fakeModule{dir: scratchDir, execute: func(cmd *exec.Cmd) error {
+				// the seeded module cache is verified before go mod tidy
+				// downloads into it
+				require.Len(t, mod.cmds, 1)
+				if tt.tidy == nil {
+					return nil
+				}
+				return tt.tidy(scratchDir)
+			}}
+
+			result, err := tidy(mod, scratch, gopathDir, gocacheDir)
+			require.NoError(t, err)
+			require.Equal(t, tt.changes, result.Changes)
+			for _, change := range result.Changes {
+				require.Contains(t, result.Patch, "diff --git a/go/"+change.Path+" b/go/"+change.Path+"\n")
+			}
+			require.Equal(t, tt.verifyError, result.VerifyError)
+
+			require.Equal(t, []Cmd{{Args: []string{"go", "mod", "tidy"}, Env: env}}, scratch.cmds)
+			require.Equal(t, []Cmd{{Args: []string{"go", "mod", "verify"}, Env: append(env, "GOPROXY=off")}}, mod.cmds)
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package tidy
+
+import (
+	"fmt"
+)
+
+func PrintResult(result Result) {
+	if len(result.Changes) == 0 {
+		fmt.Println("--> go mod tidy ... \x1b[32mup to date\x1b[0m")
+	} else {
+		fmt.Println("--> go mod tidy ... \x1b[31mout of date\x1b[0m")
+		for _, change := range result.Changes {
+			fmt.Printf("    %s ... %s\n", change.Path, change.Status)
+		}
+	}
+
+	if result.VerifyError == "" {
+		fmt.Println("--> go mod verify ... \x1b[32mverified\x1b[0m")
+	} else {
+		fmt.Println("--> go mod verify ... \x1b[31mfailed\x1b[0m")
+	}
+
+	if result.Patch != "" {
+		fmt.Printf("\n\x1b[1mapply the following patch with `git apply`:\x1b[0m\n\n%s", result.Patch)
+	}
+	if result.VerifyError != "" {
+		fmt.Printf("\n\x1b[1mgo mod verify failed:\x1b[0m\n\n%s\n", result.VerifyError)
+	}
+}
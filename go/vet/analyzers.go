@@ -0,0 +1,46 @@
+package vet
+
+import (
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/atomicalign"
+	"golang.org/x/tools/go/analysis/passes/deepequalerrors"
+	"golang.org/x/tools/go/analysis/passes/fieldalignment"
+	"golang.org/x/tools/go/analysis/passes/nilness"
+	"golang.org/x/tools/go/analysis/passes/reflectvaluecompare"
+	"golang.org/x/tools/go/analysis/passes/shadow"
+	"golang.org/x/tools/go/analysis/passes/sortslice"
+	"golang.org/x/tools/go/analysis/passes/unusedwrite"
+	vetsuite "golang.org/x/tools/go/analysis/suite/vet"
+)
+
+// VetAnalyzers are the analyzers run by `go vet`. They are always enabled.
+var VetAnalyzers = vetsuite.Suite
+
+// ExtraAnalyzers are additional analyzers compiled into the prototype that
+// may be enabled by name.
+var ExtraAnalyzers = []*analysis.Analyzer{
+	atomicalign.Analyzer,
+	deepequalerrors.Analyzer,
+	fieldalignment.Analyzer,
+	nilness.Analyzer,
+	reflectvaluecompare.Analyzer,
+	shadow.Analyzer,
+	sortslice.Analyzer,
+	unusedwrite.Analyzer,
+}
+
+func allAnalyzers() []*analysis.Analyzer {
+	all := make([]*analysis.Analyzer, 0, len(VetAnalyzers)+len(ExtraAnalyzers))
+	all = append(all, VetAnalyzers...)
+	all = append(all, ExtraAnalyzers...)
+	return all
+}
+
+func findExtraAnalyzer(name string) (*analysis.Analyzer, bool) {
+	for _, a := range ExtraAnalyzers {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return nil, false
+}
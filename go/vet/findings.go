@@ -0,0 +1,140 @@
+package vet
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Finding struct {
+	Analyzer string `json:"analyzer"`
+	Package  string `json:"package"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Message  string `json:"message"`
+}
+
+func (f Finding) Position() string {
+	return fmt.Sprintf("%s:%d:%d", f.File, f.Line, f.Column)
+}
+
+type jsonDiagnostic struct {
+	Posn    string `json:"posn"`
+	Message string `json:"message"`
+}
+
+type jsonError struct {
+	Error string `json:"error"`
+}
+
+// ParseFindings parses the output of `go vet -json`, which is a stream of
+// JSON objects of the form {package: {analyzer: [diagnostic, ...]}}, possibly
+// interspersed with "# package" comments. File names are made relative to
+// dir.
+func ParseFindings(r io.Reader, dir string) ([]Finding, error) {
+	// strip out comments so that the remainder is a valid JSON stream
+	var stream bytes.Buffer
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "#") {
+			continue
+		}
+		stream.Write(scanner.Bytes())
+		stream.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read vet output: %w", err)
+	}
+
+	var findings []Finding
+	var errs []string
+	decoder := json.NewDecoder(&stream)
+	for {
+		var results map[string]map[string]json.RawMessage
+		err := decoder.Decode(&results)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decode vet output: %w", err)
+		}
+
+		for pkg, analyzers := range results {
+			for analyzer, raw := range analyzers {
+				var diagnostics []jsonDiagnostic
+				if err := json.Unmarshal(raw, &diagnostics); err != nil {
+					var analyzerErr jsonError
+					if err := json.Unmarshal(raw, &analyzerErr); err != nil {
+						return nil, fmt.Errorf("decode vet output: %w", err)
+					}
+					errs = append(errs, fmt.Sprintf("%s: %s: %s", pkg, analyzer, analyzerErr.Error))
+					continue
+				}
+				for _, diagnostic := range diagnostics {
+					finding, err := newFinding(pkg, analyzer, diagnostic, dir)
+					if err != nil {
+						return nil, err
+					}
+					findings = append(findings, finding)
+				}
+			}
+		}
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return nil, fmt.Errorf("analysis failed:\n%s", strings.Join(errs, "\n"))
+	}
+
+	sort.Slice(findings, func(i, j int) bool {
+		fi, fj := findings[i], findings[j]
+		if fi.File != fj.File {
+			return fi.File < fj.File
+		}
+		if fi.Line != fj.Line {
+			return fi.Line < fj.Line
+		}
+		if fi.Column != fj.Column {
+			return fi.Column < fj.Column
+		}
+		return fi.Analyzer < fj.Analyzer
+	})
+
+	return findings, nil
+}
+
+func newFinding(pkg, analyzer string, diagnostic jsonDiagnostic, dir string) (Finding, error) {
+	// posn is of the form file:line:col, where file may itself contain colons
+	parts := strings.Split(diagnostic.Posn, ":")
+	if len(parts) < 3 {
+		return Finding{}, fmt.Errorf("invalid diagnostic position: %s", diagnostic.Posn)
+	}
+	line, err := strconv.Atoi(parts[len(parts)-2])
+	if err != nil {
+		return Finding{}, fmt.Errorf("invalid diagnostic position: %s", diagnostic.Posn)
+	}
+	col, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil {
+		return Finding{}, fmt.Errorf("invalid diagnostic position: %s", diagnostic.Posn)
+	}
+	file := strings.Join(parts[:len(parts)-2], ":")
+	if rel, err := filepath.Rel(dir, file); err == nil && !strings.HasPrefix(rel, "..") {
+		file = filepath.ToSlash(rel)
+	}
+
+	return Finding{
+		Analyzer: analyzer,
+		Package:  pkg,
+		File:     file,
+		Line:     line,
+		Column:   col,
+		Message:  diagnostic.Message,
+	}, nil
+}
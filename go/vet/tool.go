@@ -0,0 +1,27 @@
+package vet
+
+import (
+	"strings"
+
+	"golang.org/x/tools/go/analysis/unitchecker"
+)
+
+// IsToolInvocation returns whether the prototype was invoked by `go vet` as
+// a vet tool (via -vettool), rather than as a prototype.
+//
+// go vet invokes the tool with either -flags or -V=full to describe the tool,
+// or with the path to a JSON config file for each package to analyze.
+func IsToolInvocation(args []string) bool {
+	if len(args) < 2 {
+		return false
+	}
+	last := args[len(args)-1]
+	return last == "-flags" || strings.HasPrefix(last, "-V=") || strings.HasSuffix(last, ".cfg")
+}
+
+// RunTool runs the vet tool with every analyzer compiled into the prototype.
+// The analyzers to run are selected by go vet through flags. It never
+// returns.
+func RunTool() {
+	unitchecker.Main(allAnalyzers()...)
+}
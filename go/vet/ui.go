@@ -0,0 +1,22 @@
+package vet
+
+import (
+	"fmt"
+)
+
+func PrintFindings(findings []Finding) {
+	for _, f := range findings {
+		fmt.Printf("--> %s: \x1b[33m%s\x1b[0m: %s\n", f.Position(), f.Analyzer, f.Message)
+	}
+
+	if len(findings) == 0 {
+		fmt.Println("\x1b[32mno findings\x1b[0m")
+		return
+	}
+
+	findingsWord := "findings"
+	if len(findings) == 1 {
+		findingsWord = "finding"
+	}
+	fmt.Printf("\n\x1b[1m%d %s reported\x1b[0m\n", len(findings), findingsWord)
+}
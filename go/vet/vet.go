@@ -0,0 +1,158 @@
+package vet
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/aoldershaw/prototype-experiments/go/build"
+	"github.com/aoldershaw/prototype-experiments/go/module"
+	"github.com/aoldershaw/prototype-sdk-go"
+	"golang.org/x/tools/go/analysis"
+)
+
+type Params struct {
+	Package build.OneOrMany `json:"package"`
+
+	// Analyzers is the list of extra analyzers to run (by name) in addition
+	// to the standard go vet analyzers.
+	Analyzers []string `json:"analyzers"`
+
+	Tags    []string `json:"tags"`
+	ModMode string   `json:"mod"`
+	Cgo     bool     `json:"cgo"`
+
+	build.Caches
+}
+
+type Module interface {
+	Execute(*exec.Cmd) error
+	ResolvePackages(packages ...string) ([]module.Package, error)
+	Info() (module.Info, error)
+}
+
+func Vet(mod Module, params Params) ([]prototype.MessageResponse, error) {
+	sarifDir := "./sarif"
+	err := os.MkdirAll(sarifDir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sarif directory: %w", err)
+	}
+
+	gopathDir, gocacheDir, err := params.Caches.Prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	vetTool, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate vet tool: %w", err)
+	}
+
+	analyzers, err := enabledAnalyzers(params.Analyzers)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := mod.Info()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get module info: %w", err)
+	}
+
+	findings, err := vet(mod, params, analyzers, vetTool, gopathDir, gocacheDir, info.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeSARIF(filepath.Join(sarifDir, "vet.sarif"), findings, analyzers, info.Dir); err != nil {
+		return nil, err
+	}
+
+	PrintFindings(findings)
+
+	responses := []prototype.MessageResponse{{
+		Object: map[string]interface{}{
+			"sarif":   prototype.Artifact(sarifDir),
+			"gopath":  prototype.Artifact(gopathDir),
+			"gocache": prototype.Artifact(gocacheDir),
+		},
+	}}
+
+	if len(findings) > 0 {
+		return module.Fail(responses, fmt.Errorf("%d finding(s) reported", len(findings)))
+	}
+
+	return responses, nil
+}
+
+func enabledAnalyzers(extra []string) ([]*analysis.Analyzer, error) {
+	analyzers := append([]*analysis.Analyzer{}, VetAnalyzers...)
+	for _, name := range extra {
+		a, ok := findExtraAnalyzer(name)
+		if !ok {
+			var names []string
+			for _, a := range ExtraAnalyzers {
+				names = append(names, a.Name)
+			}
+			return nil, fmt.Errorf("unknown analyzer %q (available: %s)", name, strings.Join(names, ", "))
+		}
+		analyzers = append(analyzers, a)
+	}
+	return analyzers, nil
+}
+
+func vet(mod Module, params Params, analyzers []*analysis.Analyzer, vetTool, gopathDir, gocacheDir, dir string) ([]Finding, error) {
+	// get absolute paths since go command runs in a different directory
+	gopathDir, err := filepath.Abs(gopathDir)
+	if err != nil {
+		return nil, fmt.Errorf("get absolute path: %w", err)
+	}
+	gocacheDir, err = filepath.Abs(gocacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("get absolute path: %w", err)
+	}
+
+	if len(params.Package) == 0 {
+		params.Package = build.OneOrMany{"./..."}
+	}
+	packages, err := mod.ResolvePackages(params.Package...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate packages: %w", err)
+	}
+	if len(packages) == 0 {
+		return nil, nil
+	}
+
+	cmd := exec.Command("go", "vet", "-vettool", vetTool, "-json")
+	if params.ModMode != "" {
+		cmd.Args = append(cmd.Args, "-mod", params.ModMode)
+	}
+	if len(params.Tags) > 0 {
+		cmd.Args = append(cmd.Args, "-tags", strings.Join(params.Tags, ","))
+	}
+	// explicitly enabling analyzers disables all others
+	for _, a := range analyzers {
+		cmd.Args = append(cmd.Args, "-"+a.Name)
+	}
+	for _, pkg := range packages {
+		cmd.Args = append(cmd.Args, pkg.ImportPath)
+	}
+
+	cmd.Env = build.CacheEnv(gopathDir, gocacheDir)
+	if params.Cgo {
+		cmd.Env = append(cmd.Env, "CGO_ENABLED=1")
+	} else {
+		cmd.Env = append(cmd.Env, "CGO_ENABLED=0")
+	}
+
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	if err := mod.Execute(cmd); err != nil {
+		return nil, fmt.Errorf("vet failed: %w", err)
+	}
+
+	return ParseFindings(&stdout, dir)
+}
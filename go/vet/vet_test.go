@@ -0,0 +1,165 @@
+package vet
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/aoldershaw/prototype-experiments/go/build"
+	"github.com/aoldershaw/prototype-experiments/go/module"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/shadow"
+)
+
+const vetOutput = `# example.com/gt/c
+{
+	"example.com/gt/c": {
+		"printf": [
+			{
+				"posn": "/src/gt/c/c.go:6:14",
+				"end": "/src/gt/c/c.go:6:16",
+				"message": "fmt.Printf format %d has arg \"x\" of wrong type string"
+			}
+		]
+	}
+}
+{}
+{
+	"example.com/gt/d": {
+		"shadow": [
+			{
+				"posn": "/src/gt/d/d.go:8:3",
+				"message": "declaration of \"err\" shadows declaration at line 6"
+			}
+		],
+		"printf": [
+			{
+				"posn": "/src/gt/d/d.go:3:1",
+				"message": "bad format"
+			}
+		]
+	}
+}
+`
+
+type Cmd struct {
+	Args []string
+	Env  []string
+}
+
+type fakeModule struct {
+	packages map[string][]module.Package
+	stdout   string
+	cmds     []Cmd
+}
+
+func (m *fakeModule) ResolvePackages(packages ...string) ([]module.Package, error) {
+	var out []module.Package
+	for _, pkg := range packages {
+		cur, ok := m.packages[pkg]
+		if !ok {
+			panic(fmt.Sprintf("missing packages definition for %q", pkg))
+		}
+		out = append(out, cur...)
+	}
+	return out, nil
+}
+
+func (m *fakeModule) Info() (module.Info, error) {
+	return module.Info{Path: "example.com/gt", Dir: "/src/gt"}, nil
+}
+
+func (m *fakeModule) Execute(cmd *exec.Cmd) error {
+	m.cmds = append(m.cmds, Cmd{
+		Args: cmd.Args,
+		Env:  cmd.Env,
+	})
+	_, err := cmd.Stdout.Write([]byte(m.stdout))
+	return err
+}
+
+func TestParseFindings(t *testing.T) {
+	findings, err := ParseFindings(strings.NewReader(vetOutput), "/src/gt")
+	require.NoError(t, err)
+	require.Equal(t, []Finding{
+		{
+			Analyzer: "printf",
+			Package:  "example.com/gt/c",
+			File:     "c/c.go",
+			Line:     6,
+			Column:   14,
+			Message:  `fmt.Printf format %d has arg "x" of wrong type string`,
+		},
+		{
+			Analyzer: "printf",
+			Package:  "example.com/gt/d",
+			File:     "d/d.go",
+			Line:     3,
+			Column:   1,
+			Message:  "bad format",
+		},
+		{
+			Analyzer: "shadow",
+			Package:  "example.com/gt/d",
+			File:     "d/d.go",
+			Line:     8,
+			Column:   3,
+			Message:  `declaration of "err" shadows declaration at line 6`,
+		},
+	}, findings)
+
+	_, err = ParseFindings(strings.NewReader(`{"example.com/gt/c": {"printf": {"error": "boom"}}}`), "/src/gt")
+	require.EqualError(t, err, "analysis failed:\nexample.com/gt/c: printf: boom")
+}
+
+func TestVet(t *testing.T) {
+	const gopathDir = "/gopath"
+	const gocacheDir = "/gocache"
+
+	mod := &fakeModule{
+		packages: map[string][]module.Package{
+			"./c/...": {{Name: "c", ImportPath: "example.com/gt/c"}},
+			"./d":     {{Name: "d", ImportPath: "example.com/gt/d"}},
+		},
+		stdout: vetOutput,
+	}
+	analyzers, err := enabledAnalyzers([]string{"shadow"})
+	require.NoError(t, err)
+	require.Len(t, analyzers, len(VetAnalyzers)+1)
+
+	findings, err := vet(mod, Params{
+		Package: build.OneOrMany{"./c/...", "./d"},
+		Tags:    []string{"foo", "bar"},
+	}, []*analysis.Analyzer{analyzers[0], shadow.Analyzer}, "/bin/go-prototype", gopathDir, gocacheDir, "/src/gt")
+	require.NoError(t, err)
+	require.Len(t, findings, 3)
+
+	require.Equal(t, []Cmd{
+		{
+			Args: []string{
+				"go", "vet", "-vettool", "/bin/go-prototype", "-json",
+				"-tags", "foo,bar",
+				"-" + analyzers[0].Name, "-shadow",
+				"example.com/gt/c", "example.com/gt/d",
+			},
+			Env: []string{
+				"GOPATH=" + gopathDir,
+				"GOCACHE=" + gocacheDir,
+				"CGO_ENABLED=0",
+			},
+		},
+	}, mod.cmds)
+
+	_, err = enabledAnalyzers([]string{"bogus"})
+	require.Error(t, err)
+}
+
+func TestIsToolInvocation(t *testing.T) {
+	require.True(t, IsToolInvocation([]string{"go-prototype", "-flags"}))
+	require.True(t, IsToolInvocation([]string{"go-prototype", "-V=full"}))
+	require.True(t, IsToolInvocation([]string{"go-prototype", "-printf", "/tmp/vet.cfg"}))
+	require.False(t, IsToolInvocation([]string{"go-prototype"}))
+	require.False(t, IsToolInvocation([]string{"go-prototype", "vet"}))
+}